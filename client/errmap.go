@@ -0,0 +1,152 @@
+package memcached
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/dustin/gomemcached"
+)
+
+// How the server says an error should be retried.
+//
+// All times are in milliseconds.
+type ErrorMapRetry struct {
+	Strategy    string `json:"strategy"` // constant, linear, or exponential
+	Interval    int    `json:"interval"`
+	After       int    `json:"after"`
+	MaxDuration int    `json:"max-duration"`
+	Ceil        int    `json:"ceil"`
+}
+
+// Description of a single status code in the error map.
+type ErrorMapEntry struct {
+	Name  string         `json:"name"`
+	Desc  string         `json:"desc"`
+	Attrs []string       `json:"attrs"`
+	Retry *ErrorMapRetry `json:"retry"`
+}
+
+// The server's error map, describing how to handle each status code.
+type ErrorMap struct {
+	Version  int
+	Revision int
+	Errors   map[gomemcached.Status]ErrorMapEntry
+}
+
+// Get the server's error map.
+//
+// version is the highest error map format the caller understands.
+func (client *Client) GetErrorMap(version uint16) (*ErrorMap, error) {
+	body := make([]byte, 2)
+	binary.BigEndian.PutUint16(body, version)
+
+	res, err := client.Send(&gomemcached.MCRequest{
+		Opcode: gomemcached.GET_ERROR_MAP,
+		Body:   body,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseErrorMap(res.Body)
+}
+
+func parseErrorMap(data []byte) (*ErrorMap, error) {
+	var raw struct {
+		Version  int                      `json:"version"`
+		Revision int                      `json:"revision"`
+		Errors   map[string]ErrorMapEntry `json:"errors"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	rv := &ErrorMap{
+		Version:  raw.Version,
+		Revision: raw.Revision,
+		Errors:   make(map[gomemcached.Status]ErrorMapEntry, len(raw.Errors)),
+	}
+	for k, e := range raw.Errors {
+		status, err := strconv.ParseUint(k, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid error map status %q: %v", k, err)
+		}
+		rv.Errors[gomemcached.Status(status)] = e
+	}
+	return rv, nil
+}
+
+// Get the retry spec for a status, if the error map says it should
+// be retried.  A nil error map retries nothing.
+func (em *ErrorMap) RetrySpec(status gomemcached.Status) (*ErrorMapRetry, bool) {
+	if em == nil {
+		return nil, false
+	}
+	e, ok := em.Errors[status]
+	if !ok || e.Retry == nil {
+		return nil, false
+	}
+	for _, a := range e.Attrs {
+		switch a {
+		case "auto-retry", "retry-now", "retry-later":
+			return e.Retry, true
+		}
+	}
+	return nil, false
+}
+
+// The time to wait before the given (zero-based) retry attempt.
+func (r *ErrorMapRetry) Delay(attempt int) time.Duration {
+	if attempt == 0 {
+		return time.Duration(r.After) * time.Millisecond
+	}
+	var ms float64
+	switch r.Strategy {
+	case "linear":
+		ms = float64(r.Interval * attempt)
+	case "exponential":
+		ms = math.Pow(float64(r.Interval), float64(attempt))
+	default:
+		ms = float64(r.Interval)
+	}
+	if r.Ceil > 0 && ms > float64(r.Ceil) {
+		ms = float64(r.Ceil)
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// Send a request, retrying it as the error map describes.
+//
+// Requests failing with a status the map marks as retriable are sent
+// again after the map's delay, until they succeed, fail with another
+// status, or the retry spec's max duration runs out.  Specs without a
+// max duration are retried at most maxRetries times.  A nil error map
+// means no retries.
+func (client *Client) SendWithRetry(req *gomemcached.MCRequest,
+	em *ErrorMap, maxRetries int) (*gomemcached.MCResponse, error) {
+
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		res, err := client.Send(req)
+		if err == nil || res == nil {
+			return res, err
+		}
+		spec, ok := em.RetrySpec(res.Status)
+		if !ok {
+			return res, err
+		}
+		delay := spec.Delay(attempt)
+		if spec.MaxDuration > 0 {
+			if time.Since(start)+delay >
+				time.Duration(spec.MaxDuration)*time.Millisecond {
+				return res, err
+			}
+		} else if attempt >= maxRetries {
+			return res, err
+		}
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,153 @@
+package memcached
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dustin/gomemcached"
+)
+
+const testErrorMap = `{
+  "version": 1,
+  "revision": 4,
+  "errors": {
+    "86": {
+      "name": "ETMPFAIL",
+      "desc": "Temporary failure",
+      "attrs": ["temp", "retry-now"],
+      "retry": {"strategy": "constant", "interval": 1,
+                "after": 1, "max-duration": 1000}
+    },
+    "1": {
+      "name": "KEY_ENOENT",
+      "desc": "Not Found",
+      "attrs": ["item-only"]
+    }
+  }
+}`
+
+func TestGetErrorMap(t *testing.T) {
+	conn := newFakeConn(&gomemcached.MCResponse{
+		Opcode: gomemcached.GET_ERROR_MAP,
+		Body:   []byte(testErrorMap),
+	})
+	client, _ := Wrap(conn)
+
+	em, err := client.GetErrorMap(1)
+	if err != nil {
+		t.Fatalf("Error getting error map: %v", err)
+	}
+	if em.Version != 1 || em.Revision != 4 || len(em.Errors) != 2 {
+		t.Errorf("Unexpected error map: %+v", em)
+	}
+	if em.Errors[gomemcached.TMPFAIL].Name != "ETMPFAIL" {
+		t.Errorf("Expected TMPFAIL entry, got %+v", em.Errors)
+	}
+
+	if _, ok := em.RetrySpec(gomemcached.TMPFAIL); !ok {
+		t.Errorf("Expected TMPFAIL to be retriable")
+	}
+	if _, ok := em.RetrySpec(gomemcached.KEY_ENOENT); ok {
+		t.Errorf("Expected KEY_ENOENT not to be retriable")
+	}
+
+	reqs := conn.requests(t)
+	if !bytes.Equal(reqs[0].Body, []byte{0, 1}) {
+		t.Errorf("Expected version in body, got %v", reqs[0].Body)
+	}
+}
+
+func TestErrorMapRetryDelay(t *testing.T) {
+	tests := []struct {
+		r        ErrorMapRetry
+		attempt  int
+		expected time.Duration
+	}{
+		{ErrorMapRetry{Strategy: "constant", Interval: 10, After: 5}, 0,
+			5 * time.Millisecond},
+		{ErrorMapRetry{Strategy: "constant", Interval: 10}, 3,
+			10 * time.Millisecond},
+		{ErrorMapRetry{Strategy: "linear", Interval: 10}, 3,
+			30 * time.Millisecond},
+		{ErrorMapRetry{Strategy: "exponential", Interval: 2}, 3,
+			8 * time.Millisecond},
+		{ErrorMapRetry{Strategy: "exponential", Interval: 10, Ceil: 500},
+			3, 500 * time.Millisecond},
+	}
+
+	for _, x := range tests {
+		if got := x.r.Delay(x.attempt); got != x.expected {
+			t.Errorf("Expected %v for %+v/%d, got %v",
+				x.expected, x.r, x.attempt, got)
+		}
+	}
+}
+
+func TestSendWithRetry(t *testing.T) {
+	em, err := parseErrorMap([]byte(testErrorMap))
+	if err != nil {
+		t.Fatalf("Error parsing error map: %v", err)
+	}
+
+	conn := newFakeConn(
+		&gomemcached.MCResponse{Opcode: gomemcached.GET,
+			Status: gomemcached.TMPFAIL},
+		&gomemcached.MCResponse{Opcode: gomemcached.GET,
+			Status: gomemcached.TMPFAIL},
+		&gomemcached.MCResponse{Opcode: gomemcached.GET,
+			Body: []byte("v")},
+		&gomemcached.MCResponse{Opcode: gomemcached.GET,
+			Status: gomemcached.KEY_ENOENT})
+	client, _ := Wrap(conn)
+
+	req := &gomemcached.MCRequest{Opcode: gomemcached.GET, Key: []byte("k")}
+	res, err := client.SendWithRetry(req, em, 10)
+	if err != nil || string(res.Body) != "v" {
+		t.Errorf("Expected value after retries, got %v/%v", res, err)
+	}
+	if n := len(conn.requests(t)); n != 3 {
+		t.Errorf("Expected 3 attempts, got %d", n)
+	}
+
+	_, err = client.SendWithRetry(req, em, 10)
+	if !gomemcached.IsNotFound(err) {
+		t.Errorf("Expected not found without retry, got %v", err)
+	}
+}
+
+func TestSendWithRetryLimits(t *testing.T) {
+	em, err := parseErrorMap([]byte(`{"version": 1, "revision": 1,
+  "errors": {"86": {"name": "ETMPFAIL", "attrs": ["retry-now"],
+    "retry": {"strategy": "constant", "interval": 0}}}}`))
+	if err != nil {
+		t.Fatalf("Error parsing error map: %v", err)
+	}
+
+	const maxRetries = 3
+	responses := []*gomemcached.MCResponse{}
+	for i := 0; i < maxRetries+5; i++ {
+		responses = append(responses, &gomemcached.MCResponse{
+			Opcode: gomemcached.GET, Status: gomemcached.TMPFAIL})
+	}
+	conn := newFakeConn(responses...)
+	client, _ := Wrap(conn)
+
+	req := &gomemcached.MCRequest{Opcode: gomemcached.GET, Key: []byte("k")}
+	res, err := client.SendWithRetry(req, em, maxRetries)
+	if err == nil || res.Status != gomemcached.TMPFAIL {
+		t.Errorf("Expected TMPFAIL once retries ran out, got %v", err)
+	}
+	if n := len(conn.requests(t)); n != maxRetries+1 {
+		t.Errorf("Expected %d attempts, got %d", maxRetries+1, n)
+	}
+
+	conn = newFakeConn(responses[0])
+	client, _ = Wrap(conn)
+	if _, err := client.SendWithRetry(req, nil, maxRetries); err == nil {
+		t.Errorf("Expected TMPFAIL without an error map")
+	}
+	if n := len(conn.requests(t)); n != 1 {
+		t.Errorf("Expected a single attempt without an error map, got %d", n)
+	}
+}
@@ -2,14 +2,13 @@
 package memcached
 
 import (
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
 	"math"
 	"net"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/dustin/gomemcached"
@@ -34,6 +33,15 @@ func Connect(prot, dest string) (rv *Client, err error) {
 	return Wrap(conn)
 }
 
+// Connect to a memcached server over TLS.
+func DialTLS(addr string, cfg *tls.Config) (rv *Client, err error) {
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(conn)
+}
+
 // Wrap an existing transport.
 func Wrap(rwc io.ReadWriteCloser) (rv *Client, err error) {
 	return &Client{
@@ -44,8 +52,9 @@ func Wrap(rwc io.ReadWriteCloser) (rv *Client, err error) {
 }
 
 // Close the connection when you're done.
-func (c *Client) Close() {
-	c.conn.Close()
+func (c *Client) Close() error {
+	c.healthy = false
+	return c.conn.Close()
 }
 
 // Return false if this client has had issues communicating.
@@ -95,6 +104,169 @@ func (client *Client) Get(vb uint16, key string) (*gomemcached.MCResponse, error
 	})
 }
 
+// Update the expiration of a key without changing its value.
+//
+// A missing key fails with KEY_ENOENT (see gomemcached.IsNotFound).
+func (client *Client) Touch(vb uint16, key string, expiry uint32) error {
+	req := &gomemcached.MCRequest{
+		Opcode:  gomemcached.TOUCH,
+		VBucket: vb,
+		Key:     []byte(key),
+		Extras:  make([]byte, 4),
+	}
+	binary.BigEndian.PutUint32(req.Extras, expiry)
+	_, err := client.Send(req)
+	return err
+}
+
+// Get the value for a key and update its expiration.
+//
+// A missing key fails with KEY_ENOENT (see gomemcached.IsNotFound).
+func (client *Client) GetAndTouch(vb uint16, key string,
+	expiry uint32) (*gomemcached.MCResponse, error) {
+
+	req := &gomemcached.MCRequest{
+		Opcode:  gomemcached.GAT,
+		VBucket: vb,
+		Key:     []byte(key),
+		Extras:  make([]byte, 4),
+	}
+	binary.BigEndian.PutUint32(req.Extras, expiry)
+	return client.Send(req)
+}
+
+// Get the value for a key and lock it.
+//
+// The item stays locked for lockTime seconds (the server picks a
+// default for 0) or until it's modified or unlocked using the CAS in
+// the response.  Locking an already locked item fails with a LOCKED
+// error (see gomemcached.IsLocked), or TMPFAIL on older servers.
+func (client *Client) GetAndLock(vb uint16, key string,
+	lockTime uint32) (*gomemcached.MCResponse, error) {
+
+	req := &gomemcached.MCRequest{
+		Opcode:  gomemcached.GETL,
+		VBucket: vb,
+		Key:     []byte(key),
+		Extras:  make([]byte, 4),
+	}
+	binary.BigEndian.PutUint32(req.Extras, lockTime)
+	return client.Send(req)
+}
+
+// Unlock an item locked by GetAndLock.
+func (client *Client) Unlock(vb uint16, key string, cas uint64) error {
+	_, err := client.Send(&gomemcached.MCRequest{
+		Opcode:  gomemcached.UNLOCK_KEY,
+		VBucket: vb,
+		Key:     []byte(key),
+		Cas:     cas,
+	})
+	return err
+}
+
+// Metadata of a document, as returned by GetMeta.
+type DocMeta struct {
+	Cas      uint64
+	RevSeqno uint64 // Revision seqno, used for conflict resolution
+	Flags    uint32
+	Expiry   uint32
+	Datatype uint8
+	Deleted  bool // The document is a tombstone
+}
+
+// Get the metadata of a document without its value.
+//
+// Deleted documents whose tombstones are still around are returned
+// with Deleted set.  Keys the server knows nothing about fail with
+// KEY_ENOENT (see gomemcached.IsNotFound).
+func (client *Client) GetMeta(vb uint16, key string) (*DocMeta, error) {
+	res, err := client.Send(&gomemcached.MCRequest{
+		Opcode:  gomemcached.GET_META,
+		VBucket: vb,
+		Key:     []byte(key),
+		// Request the extended form, which includes the datatype
+		Extras: []byte{0x02},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// deleted(4) flags(4) expiry(4) seqno(8) [datatype(1)]
+	if len(res.Extras) < 4+4+4+8 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	rv := &DocMeta{
+		Cas:      res.Cas,
+		Deleted:  binary.BigEndian.Uint32(res.Extras[0:4]) != 0,
+		Flags:    binary.BigEndian.Uint32(res.Extras[4:8]),
+		Expiry:   binary.BigEndian.Uint32(res.Extras[8:12]),
+		RevSeqno: binary.BigEndian.Uint64(res.Extras[12:20]),
+	}
+	if len(res.Extras) > 20 {
+		rv.Datatype = res.Extras[20]
+	}
+	return rv, nil
+}
+
+// Options for SetWithMeta and DeleteWithMeta
+const (
+	WithMetaSkipConflictResolution = uint32(0x01)
+	WithMetaForceAccept            = uint32(0x02)
+	WithMetaRegenerateCas          = uint32(0x04)
+)
+
+func (client *Client) withMeta(opcode gomemcached.CommandCode, vb uint16,
+	key string, body []byte, meta DocMeta,
+	options uint32) (*gomemcached.MCResponse, error) {
+
+	req := &gomemcached.MCRequest{
+		Opcode:  opcode,
+		VBucket: vb,
+		Key:     []byte(key),
+		Extras:  make([]byte, 4+4+8+8),
+		Body:    body,
+	}
+	binary.BigEndian.PutUint32(req.Extras[0:4], meta.Flags)
+	binary.BigEndian.PutUint32(req.Extras[4:8], meta.Expiry)
+	binary.BigEndian.PutUint64(req.Extras[8:16], meta.RevSeqno)
+	binary.BigEndian.PutUint64(req.Extras[16:24], meta.Cas)
+	if options != 0 {
+		opts := make([]byte, 4)
+		binary.BigEndian.PutUint32(opts, options)
+		req.Extras = append(req.Extras, opts...)
+	}
+	return client.Send(req)
+}
+
+// Store a value along with its source metadata, as replication does.
+//
+// The server runs conflict resolution against the existing document
+// unless options say otherwise.  If the existing document wins, the
+// write is skipped: the response has status KEY_EEXISTS but no error
+// is returned.
+func (client *Client) SetWithMeta(vb uint16, key string, value []byte,
+	meta DocMeta, options uint32) (*gomemcached.MCResponse, error) {
+
+	res, err := client.withMeta(gomemcached.SET_WITH_META, vb, key,
+		value, meta, options)
+	if res != nil && res.Status == gomemcached.KEY_EEXISTS {
+		err = nil
+	}
+	return res, err
+}
+
+// Delete a key, recording a tombstone with the source metadata.
+//
+// Unlike SetWithMeta, losing conflict resolution is returned as a
+// KEY_EEXISTS error, since there's no response to report it in.
+func (client *Client) DeleteWithMeta(vb uint16, key string, meta DocMeta,
+	options uint32) error {
+	_, err := client.withMeta(gomemcached.DEL_WITH_META, vb, key,
+		nil, meta, options)
+	return err
+}
+
 // Delete a key.
 func (client *Client) Del(vb uint16, key string) (*gomemcached.MCResponse, error) {
 	return client.Send(&gomemcached.MCRequest{
@@ -109,6 +281,12 @@ func (client *Client) AuthList() (*gomemcached.MCResponse, error) {
 		Opcode: gomemcached.SASL_LIST_MECHS})
 }
 
+// Authenticate using SASL PLAIN.
+//
+// Call this right after connecting, before issuing any other
+// commands.  A rejected login is returned as an error with status
+// AUTH_ERROR.  PLAIN never requires another step, so an
+// AUTH_CONTINUE status is also returned as an error.
 func (client *Client) Auth(user, pass string) (*gomemcached.MCResponse, error) {
 	res, err := client.AuthList()
 
@@ -126,6 +304,99 @@ func (client *Client) Auth(user, pass string) (*gomemcached.MCResponse, error) {
 	return res, fmt.Errorf("Auth mechanism PLAIN not supported")
 }
 
+// A feature that may be negotiated with the HELLO command.
+type HelloFeature uint16
+
+const (
+	FeatureDatatype           = HelloFeature(0x01)
+	FeatureTLS                = HelloFeature(0x02)
+	FeatureTCPNoDelay         = HelloFeature(0x03)
+	FeatureMutationSeqno      = HelloFeature(0x04)
+	FeatureTCPDelay           = HelloFeature(0x05)
+	FeatureXattr              = HelloFeature(0x06)
+	FeatureXerror             = HelloFeature(0x07)
+	FeatureSelectBucket       = HelloFeature(0x08)
+	FeatureSnappy             = HelloFeature(0x0a)
+	FeatureJSON               = HelloFeature(0x0b)
+	FeatureDuplex             = HelloFeature(0x0c)
+	FeatureClustermapNotify   = HelloFeature(0x0d)
+	FeatureUnorderedExecution = HelloFeature(0x0e)
+	FeatureTracing            = HelloFeature(0x0f)
+	FeatureAltRequest         = HelloFeature(0x10)
+	FeatureSyncReplication    = HelloFeature(0x11)
+	FeatureCollections        = HelloFeature(0x12)
+)
+
+var helloFeatureNames = map[HelloFeature]string{
+	FeatureDatatype:           "Datatype",
+	FeatureTLS:                "TLS",
+	FeatureTCPNoDelay:         "TCPNoDelay",
+	FeatureMutationSeqno:      "MutationSeqno",
+	FeatureTCPDelay:           "TCPDelay",
+	FeatureXattr:              "Xattr",
+	FeatureXerror:             "Xerror",
+	FeatureSelectBucket:       "SelectBucket",
+	FeatureSnappy:             "Snappy",
+	FeatureJSON:               "JSON",
+	FeatureDuplex:             "Duplex",
+	FeatureClustermapNotify:   "ClustermapNotify",
+	FeatureUnorderedExecution: "UnorderedExecution",
+	FeatureTracing:            "Tracing",
+	FeatureAltRequest:         "AltRequest",
+	FeatureSyncReplication:    "SyncReplication",
+	FeatureCollections:        "Collections",
+}
+
+func (f HelloFeature) String() string {
+	name := helloFeatureNames[f]
+	if name == "" {
+		name = fmt.Sprintf("0x%02x", uint16(f))
+	}
+	return name
+}
+
+// Negotiate features with the server.
+//
+// The name identifies this client in the server's logs.  The
+// returned slice contains the subset of features the server enabled.
+func (client *Client) Hello(name string, features []HelloFeature) ([]HelloFeature, error) {
+	body := make([]byte, 2*len(features))
+	for i, f := range features {
+		binary.BigEndian.PutUint16(body[2*i:], uint16(f))
+	}
+
+	res, err := client.Send(&gomemcached.MCRequest{
+		Opcode: gomemcached.HELLO,
+		Key:    []byte(name),
+		Body:   body,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res.Body)%2 != 0 {
+		return nil, fmt.Errorf("Invalid HELLO response body length: %d",
+			len(res.Body))
+	}
+	rv := make([]HelloFeature, len(res.Body)/2)
+	for i := range rv {
+		rv[i] = HelloFeature(binary.BigEndian.Uint16(res.Body[2*i:]))
+	}
+	return rv, nil
+}
+
+// Select the bucket this connection operates on.
+//
+// Call this after Auth and before any other commands (including
+// starting a feed).  Fails with EACCESS if the authenticated user
+// can't access the bucket, or KEY_ENOENT if there's no such bucket.
+func (client *Client) SelectBucket(name string) error {
+	_, err := client.Send(&gomemcached.MCRequest{
+		Opcode: gomemcached.SELECT_BUCKET,
+		Key:    []byte(name)})
+	return err
+}
+
 func (client *Client) store(opcode gomemcached.CommandCode, vb uint16,
 	key string, flags int, exp int, body []byte) (*gomemcached.MCResponse, error) {
 
@@ -142,12 +413,33 @@ func (client *Client) store(opcode gomemcached.CommandCode, vb uint16,
 	return client.Send(req)
 }
 
+// Expiration value for Incr and Decr that prevents the counter from
+// being created; the operation fails with KEY_ENOENT instead.  It is
+// sent on the wire as 0xffffffff.
+const CounterNoCreate = -1
+
 // Increment a value.
+//
+// If the key doesn't exist, it's created with the value def and the
+// expiration exp, unless exp is CounterNoCreate.
 func (client *Client) Incr(vb uint16, key string,
 	amt, def uint64, exp int) (uint64, error) {
+	return client.counter(gomemcached.INCREMENT, vb, key, amt, def, exp)
+}
+
+// Decrement a value.
+//
+// Counters never go below zero.  Missing keys are handled as in Incr.
+func (client *Client) Decr(vb uint16, key string,
+	amt, def uint64, exp int) (uint64, error) {
+	return client.counter(gomemcached.DECREMENT, vb, key, amt, def, exp)
+}
+
+func (client *Client) counter(opcode gomemcached.CommandCode, vb uint16,
+	key string, amt, def uint64, exp int) (uint64, error) {
 
 	req := &gomemcached.MCRequest{
-		Opcode:  gomemcached.INCREMENT,
+		Opcode:  opcode,
 		VBucket: vb,
 		Key:     []byte(key),
 		Extras:  make([]byte, 8+8+4),
@@ -160,10 +452,62 @@ func (client *Client) Incr(vb uint16, key string,
 	if err != nil {
 		return 0, err
 	}
+	if len(resp.Body) < 8 {
+		return 0, io.ErrUnexpectedEOF
+	}
 
 	return binary.BigEndian.Uint64(resp.Body), nil
 }
 
+// Remove every item in the bucket.
+//
+// This affects the whole bucket, not a single vbucket.  A non-zero
+// delay (in seconds) schedules the flush instead of running it
+// immediately.  Fails with NOT_SUPPORTED if flush is disabled on the
+// bucket.
+func (client *Client) Flush(delay uint32) error {
+	req := &gomemcached.MCRequest{
+		Opcode: gomemcached.FLUSH,
+	}
+	if delay != 0 {
+		req.Extras = make([]byte, 4)
+		binary.BigEndian.PutUint32(req.Extras, delay)
+	}
+	_, err := client.Send(req)
+	return err
+}
+
+// Get the server's version string.
+func (client *Client) Version() (string, error) {
+	res, err := client.Send(&gomemcached.MCRequest{
+		Opcode: gomemcached.VERSION,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(res.Body), nil
+}
+
+// Opaque of the NOOP sent by Ping, used to check the response is ours.
+const pingOpaque = 0x91c3
+
+// Send a NOOP and measure the time until its response arrives.
+func (client *Client) Ping() (time.Duration, error) {
+	start := time.Now()
+	res, err := client.Send(&gomemcached.MCRequest{
+		Opcode: gomemcached.NOOP,
+		Opaque: pingOpaque,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if res.Opaque != pingOpaque {
+		client.healthy = false
+		return 0, fmt.Errorf("Unexpected response to NOOP: %v", res)
+	}
+	return time.Since(start), nil
+}
+
 // Add a value for a key (store if not exists).
 func (client *Client) Add(vb uint16, key string, flags int, exp int,
 	body []byte) (*gomemcached.MCResponse, error) {
@@ -176,33 +520,83 @@ func (client *Client) Set(vb uint16, key string, flags int, exp int,
 	return client.store(gomemcached.SET, vb, key, flags, exp, body)
 }
 
-// Get keys in bulk
+// Append data to the value of an existing key.
+//
+// If cas is non-zero, the append only succeeds if the item's current
+// CAS matches.  The response carries the item's new CAS.  A missing
+// key is reported as a NOT_STORED error.
+func (client *Client) Append(vb uint16, key string, data []byte,
+	cas uint64) (*gomemcached.MCResponse, error) {
+	return client.Send(&gomemcached.MCRequest{
+		Opcode:  gomemcached.APPEND,
+		VBucket: vb,
+		Key:     []byte(key),
+		Cas:     cas,
+		Body:    data})
+}
+
+// Prepend data to the value of an existing key.
+//
+// See Append for the handling of cas and missing keys.
+func (client *Client) Prepend(vb uint16, key string, data []byte,
+	cas uint64) (*gomemcached.MCResponse, error) {
+	return client.Send(&gomemcached.MCRequest{
+		Opcode:  gomemcached.PREPEND,
+		VBucket: vb,
+		Key:     []byte(key),
+		Cas:     cas,
+		Body:    data})
+}
+
+// Get keys in bulk.
+//
+// The GETQ requests are pipelined and terminated with a NOOP.  Missing
+// keys are left out of the result.  Responses are read through to the
+// NOOP even if some fail, and the first failure is returned with the
+// results that did arrive.  If a request can't be sent, the connection
+// is closed.
 func (client *Client) GetBulk(vb uint16, keys []string) (map[string]*gomemcached.MCResponse, error) {
 	terminalOpaque := uint32(len(keys) + 5)
 	rv := map[string]*gomemcached.MCResponse{}
-	wg := sync.WaitGroup{}
-	going := true
+	var rerr error
+	fatal := false
+	done := make(chan struct{})
 
-	defer func() {
-		going = false
-	}()
+	setErr := func(err error) {
+		if rerr == nil {
+			rerr = err
+		}
+	}
 
-	wg.Add(1)
 	go func() {
-		defer wg.Done()
-		for going {
-			res, err := client.Receive()
-			if err != nil {
+		defer close(done)
+		for {
+			res, err := getResponse(client.conn, client.hdrBuf)
+			if _, isStatus := err.(*gomemcached.MCResponse); err != nil && !isStatus {
+				// Transport failure; nothing more to read.
+				fatal = true
+				setErr(err)
 				return
 			}
-			if res.Opaque == terminalOpaque {
+			switch {
+			case res.Opaque == terminalOpaque:
 				return
+			case res.Opcode != gomemcached.GETQ:
+				fatal = true
+				setErr(fmt.Errorf("Unexpected opcode in GETQ response: %+v",
+					res))
+			case int(res.Opaque) >= len(keys):
+				fatal = true
+				setErr(fmt.Errorf("Unexpected opaque in GETQ response: %+v",
+					res))
+			case err != nil:
+				fatal = fatal || gomemcached.IsFatal(err)
+				if !gomemcached.IsNotFound(err) {
+					setErr(err)
+				}
+			default:
+				rv[keys[res.Opaque]] = res
 			}
-			if res.Opcode != gomemcached.GETQ {
-				log.Panicf("Unexpected opcode in GETQ response: %+v",
-					res)
-			}
-			rv[keys[res.Opaque]] = res
 		}
 	}()
 
@@ -214,7 +608,7 @@ func (client *Client) GetBulk(vb uint16, keys []string) (map[string]*gomemcached
 			Opaque:  uint32(i),
 		})
 		if err != nil {
-			return rv, err
+			return rv, client.abortBulk(done, err)
 		}
 	}
 
@@ -222,11 +616,62 @@ func (client *Client) GetBulk(vb uint16, keys []string) (map[string]*gomemcached
 		Opcode: gomemcached.NOOP,
 		Opaque: terminalOpaque})
 	if err != nil {
-		return rv, err
+		return rv, client.abortBulk(done, err)
 	}
 
-	wg.Wait()
+	<-done
+	if fatal {
+		client.healthy = false
+	}
+
+	return rv, rerr
+}
+
+// Close the connection after a failed bulk request write, so the
+// reader stops waiting for the NOOP.
+func (client *Client) abortBulk(done <-chan struct{}, err error) error {
+	client.Close()
+	<-done
+	return err
+}
+
+// State of a vbucket, used to filter GetAllVbSeqnos.
+type VbState uint32
+
+const (
+	VbAny     = VbState(0x00) // no filter; report every vbucket
+	VbActive  = VbState(0x01)
+	VbReplica = VbState(0x02)
+	VbPending = VbState(0x03)
+	VbDead    = VbState(0x04)
+)
+
+// Get the current high seqno of each vbucket in the given state.
+//
+// Use VbAny to get the seqnos of all vbuckets on the server.
+func (client *Client) GetAllVbSeqnos(state VbState) (map[uint16]uint64, error) {
+	req := &gomemcached.MCRequest{
+		Opcode: gomemcached.GET_ALL_VB_SEQNOS,
+	}
+	if state != VbAny {
+		req.Extras = make([]byte, 4)
+		binary.BigEndian.PutUint32(req.Extras, uint32(state))
+	}
+
+	res, err := client.Send(req)
+	if err != nil {
+		return nil, err
+	}
 
+	if len(res.Body)%10 != 0 {
+		return nil, fmt.Errorf("Invalid GET_ALL_VB_SEQNOS body length: %d",
+			len(res.Body))
+	}
+	rv := make(map[uint16]uint64, len(res.Body)/10)
+	for i := 0; i < len(res.Body); i += 10 {
+		vb := binary.BigEndian.Uint16(res.Body[i:])
+		rv[vb] = binary.BigEndian.Uint64(res.Body[i+2:])
+	}
 	return rv, nil
 }
 
@@ -302,6 +747,64 @@ func (result ObserveResult) CheckPersistence(cas uint64, deletion bool) (persist
 	return
 }
 
+// Data obtained by an ObserveSeqno call
+type ObserveSeqnoResult struct {
+	VBucketUUID  uint64 // vbucket UUID the seqnos belong to
+	PersistSeqno uint64 // Highest seqno persisted to disk
+	CurrentSeqno uint64 // Highest seqno in memory
+	// If the vbucket UUID asked about is no longer current (a hard
+	// failover happened), the old UUID and the last seqno received
+	// under it.
+	Failover       bool
+	OldVBucketUUID uint64
+	LastSeqno      uint64
+}
+
+// Gets the persisted and current seqnos of a vbucket.
+func (client *Client) ObserveSeqno(vb uint16, vbuuid uint64) (result ObserveSeqnoResult, err error) {
+	body := make([]byte, 8)
+	binary.BigEndian.PutUint64(body, vbuuid)
+
+	res, err := client.Send(&gomemcached.MCRequest{
+		Opcode:  gomemcached.OBSERVE_SEQNO,
+		VBucket: vb,
+		Body:    body,
+	})
+	if err != nil {
+		return
+	}
+
+	// format(1) vbucket(2) vbuuid(8) persisted(8) current(8)
+	if len(res.Body) < 1+2+8+8+8 {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+	format := res.Body[0]
+	if outVb := binary.BigEndian.Uint16(res.Body[1:3]); outVb != vb {
+		err = fmt.Errorf("ObserveSeqno returned wrong vbucket: %d", outVb)
+		return
+	}
+	result.VBucketUUID = binary.BigEndian.Uint64(res.Body[3:11])
+	result.PersistSeqno = binary.BigEndian.Uint64(res.Body[11:19])
+	result.CurrentSeqno = binary.BigEndian.Uint64(res.Body[19:27])
+
+	switch format {
+	case 0:
+	case 1:
+		// ... old vbuuid(8) last received seqno(8)
+		if len(res.Body) < 27+8+8 {
+			err = io.ErrUnexpectedEOF
+			return
+		}
+		result.Failover = true
+		result.OldVBucketUUID = binary.BigEndian.Uint64(res.Body[27:35])
+		result.LastSeqno = binary.BigEndian.Uint64(res.Body[35:43])
+	default:
+		err = fmt.Errorf("Unknown ObserveSeqno response format: %d", format)
+	}
+	return
+}
+
 // Operation to perform on this CAS loop.
 type CasOp uint8
 
@@ -3,9 +3,18 @@ package memcached
 import (
 	"bufio"
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
 	"io/ioutil"
+	"math/big"
+	"net"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/dustin/gomemcached"
 )
@@ -307,3 +316,778 @@ func TestCasOpError(t *testing.T) {
 		}
 	}
 }
+
+func TestClose(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	client, err := Wrap(local)
+	if err != nil {
+		t.Fatalf("Error wrapping connection: %v", err)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("Error closing client: %v", err)
+	}
+	if client.IsHealthy() {
+		t.Errorf("Expected closed client to be unhealthy")
+	}
+
+	if _, err := remote.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected read on closed connection to fail")
+	}
+}
+
+// A transport that replays canned responses and records what was
+// written to it.
+type fakeConn struct {
+	toRead  bytes.Buffer
+	written bytes.Buffer
+	closed  bool
+}
+
+func newFakeConn(responses ...*gomemcached.MCResponse) *fakeConn {
+	rv := &fakeConn{}
+	for _, res := range responses {
+		rv.toRead.Write(res.Bytes())
+	}
+	return rv
+}
+
+func (f *fakeConn) Read(p []byte) (int, error) {
+	return f.toRead.Read(p)
+}
+
+func (f *fakeConn) Write(p []byte) (int, error) {
+	return f.written.Write(p)
+}
+
+func (f *fakeConn) Close() error {
+	f.closed = true
+	return nil
+}
+
+// Decode the requests the client wrote to the fake connection.
+func (f *fakeConn) requests(t *testing.T) []gomemcached.MCRequest {
+	rv := []gomemcached.MCRequest{}
+	r := bytes.NewReader(f.written.Bytes())
+	for r.Len() > 0 {
+		var req gomemcached.MCRequest
+		if err := req.Receive(r, nil); err != nil {
+			t.Fatalf("Error decoding written request: %v", err)
+		}
+		rv = append(rv, req)
+	}
+	return rv
+}
+
+func TestHello(t *testing.T) {
+	conn := newFakeConn(&gomemcached.MCResponse{
+		Opcode: gomemcached.HELLO,
+		Body:   []byte{0x00, 0x06, 0x00, 0x0a},
+	})
+	client, _ := Wrap(conn)
+
+	got, err := client.Hello("testclient",
+		[]HelloFeature{FeatureXattr, FeatureSnappy, FeatureCollections})
+	if err != nil {
+		t.Fatalf("Error negotiating features: %v", err)
+	}
+
+	expected := []HelloFeature{FeatureXattr, FeatureSnappy}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected features %v, got %v", expected, got)
+	}
+
+	reqs := conn.requests(t)
+	if len(reqs) != 1 {
+		t.Fatalf("Expected one request, got %v", reqs)
+	}
+	if reqs[0].Opcode != gomemcached.HELLO ||
+		string(reqs[0].Key) != "testclient" ||
+		!bytes.Equal(reqs[0].Body, []byte{0, 0x06, 0, 0x0a, 0, 0x12}) {
+		t.Errorf("Unexpected HELLO request: %#v", reqs[0])
+	}
+}
+
+func TestHelloFeatureString(t *testing.T) {
+	if FeatureSnappy.String() != "Snappy" {
+		t.Errorf("Expected Snappy, got %v", FeatureSnappy)
+	}
+	if HelloFeature(0x99).String() != "0x99" {
+		t.Errorf("Expected 0x99, got %v", HelloFeature(0x99))
+	}
+}
+
+func TestAuth(t *testing.T) {
+	conn := newFakeConn(
+		&gomemcached.MCResponse{
+			Opcode: gomemcached.SASL_LIST_MECHS,
+			Body:   []byte("CRAM-MD5 PLAIN"),
+		},
+		&gomemcached.MCResponse{
+			Opcode: gomemcached.SASL_AUTH,
+			Body:   []byte("Authenticated"),
+		})
+	client, _ := Wrap(conn)
+
+	_, err := client.Auth("user", "pass")
+	if err != nil {
+		t.Fatalf("Error authenticating: %v", err)
+	}
+
+	reqs := conn.requests(t)
+	if len(reqs) != 2 {
+		t.Fatalf("Expected two requests, got %v", reqs)
+	}
+	if reqs[1].Opcode != gomemcached.SASL_AUTH ||
+		string(reqs[1].Key) != "PLAIN" ||
+		string(reqs[1].Body) != "\x00user\x00pass" {
+		t.Errorf("Unexpected auth request: %#v", reqs[1])
+	}
+}
+
+func TestAuthFailure(t *testing.T) {
+	for _, status := range []gomemcached.Status{
+		gomemcached.AUTH_ERROR, gomemcached.AUTH_CONTINUE} {
+
+		conn := newFakeConn(
+			&gomemcached.MCResponse{
+				Opcode: gomemcached.SASL_LIST_MECHS,
+				Body:   []byte("PLAIN"),
+			},
+			&gomemcached.MCResponse{
+				Opcode: gomemcached.SASL_AUTH,
+				Status: status,
+			})
+		client, _ := Wrap(conn)
+
+		res, err := client.Auth("user", "wrong")
+		if err == nil {
+			t.Errorf("Expected error for %v", status)
+		}
+		if res == nil || res.Status != status {
+			t.Errorf("Expected %v response, got %v", status, res)
+		}
+	}
+}
+
+func testTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{der},
+			PrivateKey:  key,
+		}},
+	}
+}
+
+func TestDialTLS(t *testing.T) {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", testTLSConfig(t))
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var req gomemcached.MCRequest
+		if err := req.Receive(conn, nil); err != nil {
+			return
+		}
+		res := gomemcached.MCResponse{
+			Opcode: req.Opcode,
+			Opaque: req.Opaque,
+			Body:   []byte("secret value"),
+		}
+		res.Transmit(conn)
+	}()
+
+	client, err := DialTLS(l.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer client.Close()
+
+	res, err := client.Get(0, "k")
+	if err != nil {
+		t.Fatalf("Error getting over TLS: %v", err)
+	}
+	if string(res.Body) != "secret value" {
+		t.Errorf("Expected secret value, got %q", res.Body)
+	}
+}
+
+func TestGetAllVbSeqnos(t *testing.T) {
+	conn := newFakeConn(&gomemcached.MCResponse{
+		Opcode: gomemcached.GET_ALL_VB_SEQNOS,
+		Body: []byte{
+			0x00, 0x00, 0, 0, 0, 0, 0, 0, 0x00, 0x2a,
+			0x03, 0xff, 0, 0, 0, 0, 0, 0x01, 0x00, 0x00,
+		},
+	})
+	client, _ := Wrap(conn)
+
+	got, err := client.GetAllVbSeqnos(VbActive)
+	if err != nil {
+		t.Fatalf("Error getting seqnos: %v", err)
+	}
+
+	expected := map[uint16]uint64{0: 42, 1023: 65536}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	reqs := conn.requests(t)
+	if !bytes.Equal(reqs[0].Extras, []byte{0, 0, 0, 1}) {
+		t.Errorf("Expected active state filter, got %v", reqs[0].Extras)
+	}
+}
+
+func TestAppendPrepend(t *testing.T) {
+	conn := newFakeConn(
+		&gomemcached.MCResponse{Opcode: gomemcached.APPEND, Cas: 7},
+		&gomemcached.MCResponse{Opcode: gomemcached.PREPEND,
+			Status: gomemcached.NOT_STORED})
+	client, _ := Wrap(conn)
+
+	res, err := client.Append(5, "k", []byte("tail"), 6)
+	if err != nil {
+		t.Fatalf("Error appending: %v", err)
+	}
+	if res.Cas != 7 {
+		t.Errorf("Expected new cas 7, got %v", res.Cas)
+	}
+
+	_, err = client.Prepend(5, "missing", []byte("head"), 0)
+	if err == nil || err.(*gomemcached.MCResponse).Status != gomemcached.NOT_STORED {
+		t.Errorf("Expected NOT_STORED error, got %v", err)
+	}
+
+	reqs := conn.requests(t)
+	if reqs[0].Opcode != gomemcached.APPEND || reqs[0].Cas != 6 ||
+		reqs[0].VBucket != 5 || string(reqs[0].Body) != "tail" {
+		t.Errorf("Unexpected append request: %#v", reqs[0])
+	}
+	if reqs[1].Opcode != gomemcached.PREPEND ||
+		string(reqs[1].Key) != "missing" ||
+		string(reqs[1].Body) != "head" {
+		t.Errorf("Unexpected prepend request: %#v", reqs[1])
+	}
+}
+
+func TestIncrDecr(t *testing.T) {
+	conn := newFakeConn(
+		&gomemcached.MCResponse{Opcode: gomemcached.INCREMENT,
+			Body: []byte{0, 0, 0, 0, 0, 0, 0, 11}},
+		&gomemcached.MCResponse{Opcode: gomemcached.DECREMENT,
+			Body: []byte{0, 0, 0, 0, 0, 0, 0, 8}},
+		&gomemcached.MCResponse{Opcode: gomemcached.DECREMENT,
+			Status: gomemcached.KEY_ENOENT})
+	client, _ := Wrap(conn)
+
+	v, err := client.Incr(0, "c", 1, 10, 60)
+	if err != nil || v != 11 {
+		t.Errorf("Expected 11, got %v/%v", v, err)
+	}
+	v, err = client.Decr(0, "c", 3, 0, 0)
+	if err != nil || v != 8 {
+		t.Errorf("Expected 8, got %v/%v", v, err)
+	}
+	_, err = client.Decr(0, "missing", 1, 0, CounterNoCreate)
+	if !gomemcached.IsNotFound(err) {
+		t.Errorf("Expected not found, got %v", err)
+	}
+
+	reqs := conn.requests(t)
+	expected := []byte{
+		0, 0, 0, 0, 0, 0, 0, 1, // delta
+		0, 0, 0, 0, 0, 0, 0, 10, // initial
+		0, 0, 0, 60, // expiration
+	}
+	if !bytes.Equal(reqs[0].Extras, expected) {
+		t.Errorf("Expected incr extras %v, got %v", expected, reqs[0].Extras)
+	}
+	if reqs[1].Opcode != gomemcached.DECREMENT {
+		t.Errorf("Expected DECREMENT, got %v", reqs[1].Opcode)
+	}
+	if !bytes.Equal(reqs[2].Extras[16:], []byte{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("Expected no-create expiration, got %v", reqs[2].Extras)
+	}
+}
+
+func TestGetAndLock(t *testing.T) {
+	conn := newFakeConn(
+		&gomemcached.MCResponse{Opcode: gomemcached.GETL,
+			Cas: 99, Body: []byte("v")},
+		&gomemcached.MCResponse{Opcode: gomemcached.GETL,
+			Status: gomemcached.LOCKED},
+		&gomemcached.MCResponse{Opcode: gomemcached.UNLOCK_KEY})
+	client, _ := Wrap(conn)
+
+	res, err := client.GetAndLock(2, "k", 15)
+	if err != nil || res.Cas != 99 {
+		t.Fatalf("Expected locked item with cas 99, got %v/%v", res, err)
+	}
+
+	_, err = client.GetAndLock(2, "k", 15)
+	if !gomemcached.IsLocked(err) {
+		t.Errorf("Expected LOCKED error, got %v", err)
+	}
+	if !client.IsHealthy() {
+		t.Errorf("Expected client to stay healthy after LOCKED")
+	}
+
+	if err := client.Unlock(2, "k", 99); err != nil {
+		t.Errorf("Error unlocking: %v", err)
+	}
+
+	reqs := conn.requests(t)
+	if !bytes.Equal(reqs[0].Extras, []byte{0, 0, 0, 15}) {
+		t.Errorf("Expected lock time extras, got %v", reqs[0].Extras)
+	}
+	if reqs[2].Opcode != gomemcached.UNLOCK_KEY || reqs[2].Cas != 99 {
+		t.Errorf("Unexpected unlock request: %#v", reqs[2])
+	}
+}
+
+func TestSelectBucket(t *testing.T) {
+	conn := newFakeConn(
+		&gomemcached.MCResponse{Opcode: gomemcached.SELECT_BUCKET},
+		&gomemcached.MCResponse{Opcode: gomemcached.SELECT_BUCKET,
+			Status: gomemcached.EACCESS})
+	client, _ := Wrap(conn)
+
+	if err := client.SelectBucket("default"); err != nil {
+		t.Errorf("Error selecting bucket: %v", err)
+	}
+
+	err := client.SelectBucket("secret")
+	if res, ok := err.(*gomemcached.MCResponse); !ok ||
+		res.Status != gomemcached.EACCESS {
+		t.Errorf("Expected EACCESS, got %v", err)
+	}
+	if !client.IsHealthy() {
+		t.Errorf("Expected client to stay healthy after EACCESS")
+	}
+
+	reqs := conn.requests(t)
+	if reqs[0].Opcode != gomemcached.SELECT_BUCKET ||
+		string(reqs[0].Key) != "default" {
+		t.Errorf("Unexpected select request: %#v", reqs[0])
+	}
+}
+
+func TestObserveSeqno(t *testing.T) {
+	body := []byte{
+		0x00,       // format
+		0x00, 0x05, // vbucket
+		0, 0, 0, 0, 0, 0, 0xab, 0xcd, // vbuuid
+		0, 0, 0, 0, 0, 0, 0, 10, // persisted seqno
+		0, 0, 0, 0, 0, 0, 0, 12, // current seqno
+	}
+	failover := append([]byte{0x01}, body[1:]...)
+	failover = append(failover,
+		0, 0, 0, 0, 0, 0, 0x12, 0x34, // old vbuuid
+		0, 0, 0, 0, 0, 0, 0, 9, // last received seqno
+	)
+
+	conn := newFakeConn(
+		&gomemcached.MCResponse{Opcode: gomemcached.OBSERVE_SEQNO,
+			Body: body},
+		&gomemcached.MCResponse{Opcode: gomemcached.OBSERVE_SEQNO,
+			Body: failover})
+	client, _ := Wrap(conn)
+
+	got, err := client.ObserveSeqno(5, 0xabcd)
+	if err != nil {
+		t.Fatalf("Error observing seqno: %v", err)
+	}
+	expected := ObserveSeqnoResult{
+		VBucketUUID:  0xabcd,
+		PersistSeqno: 10,
+		CurrentSeqno: 12,
+	}
+	if got != expected {
+		t.Errorf("Expected %+v, got %+v", expected, got)
+	}
+
+	got, err = client.ObserveSeqno(5, 0x1234)
+	if err != nil {
+		t.Fatalf("Error observing seqno: %v", err)
+	}
+	expected.Failover = true
+	expected.OldVBucketUUID = 0x1234
+	expected.LastSeqno = 9
+	if got != expected {
+		t.Errorf("Expected %+v, got %+v", expected, got)
+	}
+
+	reqs := conn.requests(t)
+	if reqs[0].VBucket != 5 ||
+		!bytes.Equal(reqs[0].Body, []byte{0, 0, 0, 0, 0, 0, 0xab, 0xcd}) {
+		t.Errorf("Unexpected observe seqno request: %#v", reqs[0])
+	}
+}
+
+func TestGetMeta(t *testing.T) {
+	conn := newFakeConn(
+		&gomemcached.MCResponse{
+			Opcode: gomemcached.GET_META,
+			Cas:    0x1122,
+			Extras: []byte{
+				0, 0, 0, 1, // deleted
+				0, 0, 0, 2, // flags
+				0, 0, 0, 3, // expiry
+				0, 0, 0, 0, 0, 0, 0, 4, // rev seqno
+				gomemcached.DATATYPE_JSON,
+			},
+		},
+		&gomemcached.MCResponse{Opcode: gomemcached.GET_META,
+			Status: gomemcached.KEY_ENOENT})
+	client, _ := Wrap(conn)
+
+	got, err := client.GetMeta(1, "k")
+	if err != nil {
+		t.Fatalf("Error getting meta: %v", err)
+	}
+	expected := DocMeta{
+		Cas:      0x1122,
+		RevSeqno: 4,
+		Flags:    2,
+		Expiry:   3,
+		Datatype: gomemcached.DATATYPE_JSON,
+		Deleted:  true,
+	}
+	if *got != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *got)
+	}
+
+	_, err = client.GetMeta(1, "missing")
+	if !gomemcached.IsNotFound(err) {
+		t.Errorf("Expected not found, got %v", err)
+	}
+
+	reqs := conn.requests(t)
+	if reqs[0].Opcode != gomemcached.GET_META ||
+		!bytes.Equal(reqs[0].Extras, []byte{0x02}) {
+		t.Errorf("Unexpected get meta request: %#v", reqs[0])
+	}
+}
+
+func TestSetWithMeta(t *testing.T) {
+	conn := newFakeConn(
+		&gomemcached.MCResponse{Opcode: gomemcached.SET_WITH_META},
+		&gomemcached.MCResponse{Opcode: gomemcached.SET_WITH_META,
+			Status: gomemcached.KEY_EEXISTS})
+	client, _ := Wrap(conn)
+
+	meta := DocMeta{Cas: 0x0a, RevSeqno: 0x0b, Flags: 0x0c, Expiry: 0x0d}
+	_, err := client.SetWithMeta(3, "k", []byte("v"), meta, 0)
+	if err != nil {
+		t.Fatalf("Error setting with meta: %v", err)
+	}
+
+	res, err := client.SetWithMeta(3, "k", []byte("v"), meta,
+		WithMetaRegenerateCas)
+	if err != nil || res.Status != gomemcached.KEY_EEXISTS {
+		t.Errorf("Expected skipped write without error, got %v/%v", res, err)
+	}
+
+	reqs := conn.requests(t)
+	expected := []byte{
+		0, 0, 0, 0x0c, // flags
+		0, 0, 0, 0x0d, // expiry
+		0, 0, 0, 0, 0, 0, 0, 0x0b, // rev seqno
+		0, 0, 0, 0, 0, 0, 0, 0x0a, // cas
+	}
+	if !bytes.Equal(reqs[0].Extras, expected) ||
+		string(reqs[0].Body) != "v" {
+		t.Errorf("Unexpected set with meta request: %#v", reqs[0])
+	}
+	if !bytes.Equal(reqs[1].Extras, append(expected, 0, 0, 0, 0x04)) {
+		t.Errorf("Expected options in extras, got %v", reqs[1].Extras)
+	}
+}
+
+func TestDeleteWithMeta(t *testing.T) {
+	conn := newFakeConn(
+		&gomemcached.MCResponse{Opcode: gomemcached.DEL_WITH_META},
+		&gomemcached.MCResponse{Opcode: gomemcached.DEL_WITH_META,
+			Status: gomemcached.KEY_EEXISTS})
+	client, _ := Wrap(conn)
+
+	meta := DocMeta{Cas: 1, RevSeqno: 2}
+	if err := client.DeleteWithMeta(3, "k", meta, 0); err != nil {
+		t.Fatalf("Error deleting with meta: %v", err)
+	}
+
+	err := client.DeleteWithMeta(3, "k", meta, 0)
+	if res, ok := err.(*gomemcached.MCResponse); !ok ||
+		res.Status != gomemcached.KEY_EEXISTS {
+		t.Errorf("Expected KEY_EEXISTS, got %v", err)
+	}
+	if !client.IsHealthy() {
+		t.Errorf("Expected conflict to be non-fatal")
+	}
+
+	reqs := conn.requests(t)
+	if reqs[0].Opcode != gomemcached.DEL_WITH_META ||
+		len(reqs[0].Extras) != 24 || len(reqs[0].Body) != 0 {
+		t.Errorf("Unexpected delete with meta request: %#v", reqs[0])
+	}
+}
+
+func TestGetBulk(t *testing.T) {
+	keys := []string{"a", "missing", "c"}
+	conn := newFakeConn(
+		&gomemcached.MCResponse{Opcode: gomemcached.GETQ,
+			Opaque: 0, Body: []byte("va")},
+		&gomemcached.MCResponse{Opcode: gomemcached.GETQ,
+			Opaque: 2, Body: []byte("vc")},
+		&gomemcached.MCResponse{Opcode: gomemcached.NOOP,
+			Opaque: uint32(len(keys) + 5)})
+	client, _ := Wrap(conn)
+
+	got, err := client.GetBulk(1, keys)
+	if err != nil {
+		t.Fatalf("Error getting bulk: %v", err)
+	}
+	if len(got) != 2 || string(got["a"].Body) != "va" ||
+		string(got["c"].Body) != "vc" {
+		t.Errorf("Unexpected bulk results: %v", got)
+	}
+
+	reqs := conn.requests(t)
+	if len(reqs) != len(keys)+1 {
+		t.Fatalf("Expected %d requests, got %v", len(keys)+1, reqs)
+	}
+	for i, k := range keys {
+		if reqs[i].Opcode != gomemcached.GETQ ||
+			string(reqs[i].Key) != k || reqs[i].Opaque != uint32(i) {
+			t.Errorf("Unexpected request %d: %#v", i, reqs[i])
+		}
+	}
+	if reqs[len(keys)].Opcode != gomemcached.NOOP {
+		t.Errorf("Expected terminating NOOP, got %v", reqs[len(keys)])
+	}
+}
+
+func TestGetBulkErrorDrainsPipeline(t *testing.T) {
+	keys := []string{"a", "b", "c"}
+	conn := newFakeConn(
+		&gomemcached.MCResponse{Opcode: gomemcached.GETQ,
+			Opaque: 0, Body: []byte("va")},
+		&gomemcached.MCResponse{Opcode: gomemcached.GETQ,
+			Opaque: 1, Status: gomemcached.NOT_MY_VBUCKET},
+		&gomemcached.MCResponse{Opcode: gomemcached.GETQ,
+			Opaque: 2, Body: []byte("vc")},
+		&gomemcached.MCResponse{Opcode: gomemcached.NOOP,
+			Opaque: uint32(len(keys) + 5)},
+		&gomemcached.MCResponse{Opcode: gomemcached.GET,
+			Body: []byte("next")})
+	client, _ := Wrap(conn)
+
+	got, err := client.GetBulk(1, keys)
+	res, ok := err.(*gomemcached.MCResponse)
+	if !ok || res.Status != gomemcached.NOT_MY_VBUCKET {
+		t.Fatalf("Expected NOT_MY_VBUCKET, got %v", err)
+	}
+	if len(got) != 2 || string(got["a"].Body) != "va" ||
+		string(got["c"].Body) != "vc" {
+		t.Errorf("Unexpected bulk results: %v", got)
+	}
+
+	// The NOOP was consumed, so the next response is ours.
+	res, err = client.Get(1, "k")
+	if err != nil || string(res.Body) != "next" {
+		t.Errorf("Expected the following response, got %v/%v", res, err)
+	}
+}
+
+func TestGetBulkUnexpectedResponses(t *testing.T) {
+	keys := []string{"a"}
+	tests := []*gomemcached.MCResponse{
+		{Opcode: gomemcached.GET, Opaque: 0},
+		{Opcode: gomemcached.GETQ, Opaque: 100},
+	}
+
+	for _, bad := range tests {
+		conn := newFakeConn(bad,
+			&gomemcached.MCResponse{Opcode: gomemcached.GETQ,
+				Opaque: 0, Body: []byte("va")},
+			&gomemcached.MCResponse{Opcode: gomemcached.NOOP,
+				Opaque: uint32(len(keys) + 5)})
+		client, _ := Wrap(conn)
+
+		got, err := client.GetBulk(1, keys)
+		if err == nil {
+			t.Errorf("Expected error for %v", bad)
+		}
+		if string(got["a"].Body) != "va" {
+			t.Errorf("Expected remaining results for %v, got %v", bad, got)
+		}
+		if conn.toRead.Len() != 0 {
+			t.Errorf("Expected pipeline drained for %v", bad)
+		}
+		if client.IsHealthy() {
+			t.Errorf("Expected unhealthy client after %v", bad)
+		}
+	}
+}
+
+func TestGetBulkConnectionLost(t *testing.T) {
+	conn := newFakeConn(&gomemcached.MCResponse{Opcode: gomemcached.GETQ,
+		Opaque: 0, Body: []byte("va")})
+	client, _ := Wrap(conn)
+
+	_, err := client.GetBulk(1, []string{"a", "b"})
+	if err == nil || client.IsHealthy() {
+		t.Errorf("Expected error and unhealthy client, got %v", err)
+	}
+}
+
+type failingWriteConn struct {
+	fakeConn
+}
+
+func (f *failingWriteConn) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestGetBulkTransmitFailure(t *testing.T) {
+	conn := &failingWriteConn{}
+	client, _ := Wrap(conn)
+
+	_, err := client.GetBulk(1, []string{"a", "b"})
+	if err != io.ErrClosedPipe {
+		t.Errorf("Expected write error, got %v", err)
+	}
+	if !conn.closed || client.IsHealthy() {
+		t.Errorf("Expected closed, unhealthy client")
+	}
+}
+
+func TestTouch(t *testing.T) {
+	conn := newFakeConn(
+		&gomemcached.MCResponse{Opcode: gomemcached.TOUCH},
+		&gomemcached.MCResponse{Opcode: gomemcached.GAT,
+			Body: []byte("v")},
+		&gomemcached.MCResponse{Opcode: gomemcached.TOUCH,
+			Status: gomemcached.KEY_ENOENT})
+	client, _ := Wrap(conn)
+
+	if err := client.Touch(2, "k", 300); err != nil {
+		t.Errorf("Error touching: %v", err)
+	}
+	res, err := client.GetAndTouch(2, "k", 600)
+	if err != nil || string(res.Body) != "v" {
+		t.Errorf("Expected value v, got %v/%v", res, err)
+	}
+	if err := client.Touch(2, "missing", 300); !gomemcached.IsNotFound(err) {
+		t.Errorf("Expected not found, got %v", err)
+	}
+
+	reqs := conn.requests(t)
+	if reqs[0].Opcode != gomemcached.TOUCH ||
+		!bytes.Equal(reqs[0].Extras, []byte{0, 0, 0x01, 0x2c}) {
+		t.Errorf("Unexpected touch request: %#v", reqs[0])
+	}
+	if reqs[1].Opcode != gomemcached.GAT ||
+		!bytes.Equal(reqs[1].Extras, []byte{0, 0, 0x02, 0x58}) {
+		t.Errorf("Unexpected get and touch request: %#v", reqs[1])
+	}
+}
+
+func TestFlush(t *testing.T) {
+	conn := newFakeConn(
+		&gomemcached.MCResponse{Opcode: gomemcached.FLUSH},
+		&gomemcached.MCResponse{Opcode: gomemcached.FLUSH,
+			Status: gomemcached.NOT_SUPPORTED})
+	client, _ := Wrap(conn)
+
+	if err := client.Flush(0); err != nil {
+		t.Errorf("Error flushing: %v", err)
+	}
+
+	err := client.Flush(30)
+	if res, ok := err.(*gomemcached.MCResponse); !ok ||
+		res.Status != gomemcached.NOT_SUPPORTED {
+		t.Errorf("Expected NOT_SUPPORTED, got %v", err)
+	}
+	if !client.IsHealthy() {
+		t.Errorf("Expected client to stay healthy after NOT_SUPPORTED")
+	}
+
+	reqs := conn.requests(t)
+	if len(reqs[0].Extras) != 0 {
+		t.Errorf("Expected no extras for immediate flush, got %v",
+			reqs[0].Extras)
+	}
+	if !bytes.Equal(reqs[1].Extras, []byte{0, 0, 0, 30}) {
+		t.Errorf("Expected delay in extras, got %v", reqs[1].Extras)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	conn := newFakeConn(&gomemcached.MCResponse{
+		Opcode: gomemcached.VERSION,
+		Body:   []byte("1.4.15"),
+	})
+	client, _ := Wrap(conn)
+
+	v, err := client.Version()
+	if err != nil || v != "1.4.15" {
+		t.Errorf("Expected version 1.4.15, got %q/%v", v, err)
+	}
+
+	reqs := conn.requests(t)
+	if reqs[0].Opcode != gomemcached.VERSION {
+		t.Errorf("Expected VERSION request, got %v", reqs[0])
+	}
+}
+
+func TestPing(t *testing.T) {
+	conn := newFakeConn(
+		&gomemcached.MCResponse{Opcode: gomemcached.NOOP,
+			Opaque: pingOpaque},
+		&gomemcached.MCResponse{Opcode: gomemcached.GET,
+			Opaque: 1})
+	client, _ := Wrap(conn)
+
+	d, err := client.Ping()
+	if err != nil || d < 0 {
+		t.Errorf("Expected round trip time, got %v/%v", d, err)
+	}
+
+	if _, err := client.Ping(); err == nil {
+		t.Errorf("Expected error for mismatched response")
+	}
+	if client.IsHealthy() {
+		t.Errorf("Expected mismatched response to mark client unhealthy")
+	}
+
+	reqs := conn.requests(t)
+	if reqs[0].Opcode != gomemcached.NOOP {
+		t.Errorf("Expected NOOP request, got %v", reqs[0])
+	}
+}
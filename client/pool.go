@@ -0,0 +1,118 @@
+package memcached
+
+import (
+	"fmt"
+)
+
+// A bounded pool of client connections to a single server.
+//
+// Idle connections are verified with a NOOP before being handed out
+// again, and broken ones are discarded.
+type Pool struct {
+	connections chan *Client  // idle connections
+	open        chan struct{} // one entry per open connection
+	mkConn      func() (*Client, error)
+}
+
+// Create a pool of at most maxOpen connections to dest, of which at
+// most maxIdle are kept open while unused.
+//
+// maxOpen must be at least 1; maxIdle is limited to maxOpen.
+func NewPool(prot, dest string, maxOpen, maxIdle int) (*Pool, error) {
+	if maxOpen < 1 {
+		return nil, fmt.Errorf("Invalid maxOpen %d, must be at least 1",
+			maxOpen)
+	}
+	if maxIdle > maxOpen {
+		maxIdle = maxOpen
+	}
+	if maxIdle < 0 {
+		maxIdle = 0
+	}
+	return &Pool{
+		connections: make(chan *Client, maxIdle),
+		open:        make(chan struct{}, maxOpen),
+		mkConn: func() (*Client, error) {
+			return Connect(prot, dest)
+		},
+	}, nil
+}
+
+// Get a healthy connection from the pool, or make a new one.
+//
+// If the pool already has its maximum number of connections open, Get
+// waits for one to be Put back.  Every connection from Get must be
+// returned with Put, even if it's broken.
+func (p *Pool) Get() (*Client, error) {
+	for {
+		// Prefer an idle connection over opening another.
+		select {
+		case c := <-p.connections:
+			if p.check(c) {
+				return c, nil
+			}
+			p.discard(c)
+			continue
+		default:
+		}
+
+		select {
+		case c := <-p.connections:
+			if p.check(c) {
+				return c, nil
+			}
+			p.discard(c)
+		case p.open <- struct{}{}:
+			c, err := p.mkConn()
+			if err != nil {
+				<-p.open
+			}
+			return c, err
+		}
+	}
+}
+
+func (p *Pool) check(c *Client) bool {
+	if !c.IsHealthy() {
+		return false
+	}
+	_, err := c.Ping()
+	return err == nil
+}
+
+// Close a connection and release its slot.
+func (p *Pool) discard(c *Client) {
+	c.Close()
+	<-p.open
+}
+
+// Return a connection to the pool.
+//
+// Unhealthy connections, and any beyond the pool's idle limit, are
+// closed.
+func (p *Pool) Put(c *Client) {
+	if c == nil {
+		return
+	}
+	if !c.IsHealthy() {
+		p.discard(c)
+		return
+	}
+	select {
+	case p.connections <- c:
+	default:
+		p.discard(c)
+	}
+}
+
+// Close all idle connections in the pool.
+func (p *Pool) Close() {
+	for {
+		select {
+		case c := <-p.connections:
+			p.discard(c)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package memcached
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dustin/gomemcached"
+)
+
+func testPool(maxOpen, maxIdle int, conns ...*fakeConn) *Pool {
+	return &Pool{
+		connections: make(chan *Client, maxIdle),
+		open:        make(chan struct{}, maxOpen),
+		mkConn: func() (*Client, error) {
+			c := conns[0]
+			conns = conns[1:]
+			return Wrap(c)
+		},
+	}
+}
+
+func TestPoolReuse(t *testing.T) {
+	conn := newFakeConn(&gomemcached.MCResponse{
+		Opcode: gomemcached.NOOP,
+		Opaque: pingOpaque,
+	})
+	p := testPool(1, 1, conn)
+
+	c, err := p.Get()
+	if err != nil {
+		t.Fatalf("Error getting connection: %v", err)
+	}
+	p.Put(c)
+
+	c2, err := p.Get()
+	if err != nil {
+		t.Fatalf("Error getting connection: %v", err)
+	}
+	if c2 != c {
+		t.Errorf("Expected pooled connection to be reused")
+	}
+
+	reqs := conn.requests(t)
+	if len(reqs) != 1 || reqs[0].Opcode != gomemcached.NOOP {
+		t.Errorf("Expected a NOOP health check, got %v", reqs)
+	}
+}
+
+func TestPoolDiscardsBroken(t *testing.T) {
+	broken := newFakeConn()
+	fresh := newFakeConn()
+	p := testPool(1, 1, broken, fresh)
+
+	c, _ := p.Get()
+	p.Put(c)
+
+	// The NOOP check fails with EOF, so a new connection is made.
+	c2, err := p.Get()
+	if err != nil {
+		t.Fatalf("Error getting connection: %v", err)
+	}
+	if c2 == c || !broken.closed {
+		t.Errorf("Expected broken connection to be closed and replaced")
+	}
+}
+
+func TestPoolPutUnhealthy(t *testing.T) {
+	conn := newFakeConn()
+	p := testPool(1, 1, conn)
+
+	c, _ := p.Get()
+	c.healthy = false
+	p.Put(c)
+
+	if !conn.closed || len(p.connections) != 0 {
+		t.Errorf("Expected unhealthy connection to be closed")
+	}
+}
+
+func TestPoolMaxIdle(t *testing.T) {
+	a, b := newFakeConn(), newFakeConn()
+	p := testPool(2, 1, a, b)
+
+	c1, _ := p.Get()
+	c2, _ := p.Get()
+	p.Put(c1)
+	p.Put(c2)
+
+	if a.closed || !b.closed {
+		t.Errorf("Expected only the connection over the limit to close")
+	}
+
+	p.Close()
+	if !a.closed {
+		t.Errorf("Expected Close to close idle connections")
+	}
+}
+
+func TestPoolMaxOpen(t *testing.T) {
+	conn := newFakeConn(&gomemcached.MCResponse{
+		Opcode: gomemcached.NOOP,
+		Opaque: pingOpaque,
+	})
+	p := testPool(1, 1, conn)
+
+	c, err := p.Get()
+	if err != nil {
+		t.Fatalf("Error getting connection: %v", err)
+	}
+
+	got := make(chan *Client)
+	go func() {
+		c2, _ := p.Get()
+		got <- c2
+	}()
+
+	select {
+	case <-got:
+		t.Fatalf("Expected Get to wait while the pool is full")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	p.Put(c)
+	select {
+	case c2 := <-got:
+		if c2 != c {
+			t.Errorf("Expected the returned connection to be reused")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected Get to return after Put")
+	}
+}
+
+func TestNewPool(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	addr := l.Addr().String()
+
+	p, err := NewPool("tcp", addr, 1, 1)
+	if err != nil {
+		t.Fatalf("Error creating pool: %v", err)
+	}
+	c, err := p.Get()
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	p.Put(c)
+	p.Close()
+	if c.IsHealthy() {
+		t.Errorf("Expected Close to close the idle connection")
+	}
+
+	// Failed dials don't use up the pool's connections.
+	l.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := p.Get(); err == nil {
+			t.Errorf("Expected error connecting to closed listener")
+		}
+	}
+}
+
+func TestNewPoolLimits(t *testing.T) {
+	for _, maxOpen := range []int{0, -1} {
+		if _, err := NewPool("tcp", "localhost:11211", maxOpen, 0); err == nil {
+			t.Errorf("Expected error for maxOpen %d", maxOpen)
+		}
+	}
+
+	p, err := NewPool("tcp", "localhost:11211", 2, 5)
+	if err != nil {
+		t.Fatalf("Error creating pool: %v", err)
+	}
+	if cap(p.connections) != 2 || cap(p.open) != 2 {
+		t.Errorf("Expected maxIdle limited to 2, got %d/%d",
+			cap(p.connections), cap(p.open))
+	}
+
+	p, err = NewPool("tcp", "localhost:11211", 2, -1)
+	if err != nil || cap(p.connections) != 0 {
+		t.Errorf("Expected negative maxIdle treated as 0, got %v", err)
+	}
+}
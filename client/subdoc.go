@@ -0,0 +1,168 @@
+package memcached
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/dustin/gomemcached"
+)
+
+// A single path operation within a LookupIn or MutateIn call.
+type SubdocSpec struct {
+	// The path operation, e.g. gomemcached.SUBDOC_GET or
+	// gomemcached.SUBDOC_DICT_UPSERT
+	Opcode gomemcached.CommandCode
+	// Per-path flags (e.g. create intermediate paths)
+	Flags uint8
+	// Path within the document
+	Path string
+	// Value for mutations; ignored for lookups
+	Value []byte
+}
+
+// The outcome of a single SubdocSpec.
+type SubdocOpResult struct {
+	Status gomemcached.Status
+	Value  []byte
+}
+
+// Results of a LookupIn or MutateIn call, one per spec.
+type SubdocResult struct {
+	Cas     uint64
+	Results []SubdocOpResult
+}
+
+// Look up several paths within a document at once.
+//
+// Failure of an individual path isn't an error; check the Status of
+// each result.
+func (client *Client) LookupIn(vb uint16, key string,
+	specs []SubdocSpec) (*SubdocResult, error) {
+
+	size := 0
+	for _, s := range specs {
+		size += 1 + 1 + 2 + len(s.Path)
+	}
+	body := make([]byte, size)
+	pos := 0
+	for _, s := range specs {
+		// opcode(1) flags(1) pathlen(2) path
+		body[pos] = byte(s.Opcode)
+		body[pos+1] = s.Flags
+		binary.BigEndian.PutUint16(body[pos+2:], uint16(len(s.Path)))
+		pos += 4
+		pos += copy(body[pos:], s.Path)
+	}
+
+	res, err := client.Send(&gomemcached.MCRequest{
+		Opcode:  gomemcached.SUBDOC_MULTI_LOOKUP,
+		VBucket: vb,
+		Key:     []byte(key),
+		Body:    body,
+	})
+	if err != nil && res != nil &&
+		res.Status == gomemcached.SUBDOC_MULTI_PATH_FAILURE {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rv := &SubdocResult{
+		Cas:     res.Cas,
+		Results: make([]SubdocOpResult, len(specs)),
+	}
+	pos = 0
+	for i := range specs {
+		// status(2) valuelen(4) value
+		if len(res.Body) < pos+6 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		status := binary.BigEndian.Uint16(res.Body[pos:])
+		vlen := int(binary.BigEndian.Uint32(res.Body[pos+2:]))
+		pos += 6
+		if len(res.Body) < pos+vlen {
+			return nil, io.ErrUnexpectedEOF
+		}
+		rv.Results[i] = SubdocOpResult{
+			Status: gomemcached.Status(status),
+			Value:  res.Body[pos : pos+vlen],
+		}
+		pos += vlen
+	}
+	return rv, nil
+}
+
+// Atomically apply several path mutations to a document.
+//
+// Either all mutations are applied or none are.  If one fails, the
+// error has status SUBDOC_MULTI_PATH_FAILURE and only the failing
+// spec's result is filled in.  Results for specs that return a value
+// (such as SUBDOC_COUNTER) carry it on success.
+func (client *Client) MutateIn(vb uint16, key string,
+	specs []SubdocSpec) (*SubdocResult, error) {
+
+	size := 0
+	for _, s := range specs {
+		size += 1 + 1 + 2 + 4 + len(s.Path) + len(s.Value)
+	}
+	body := make([]byte, size)
+	pos := 0
+	for _, s := range specs {
+		// opcode(1) flags(1) pathlen(2) valuelen(4) path value
+		body[pos] = byte(s.Opcode)
+		body[pos+1] = s.Flags
+		binary.BigEndian.PutUint16(body[pos+2:], uint16(len(s.Path)))
+		binary.BigEndian.PutUint32(body[pos+4:], uint32(len(s.Value)))
+		pos += 8
+		pos += copy(body[pos:], s.Path)
+		pos += copy(body[pos:], s.Value)
+	}
+
+	res, err := client.Send(&gomemcached.MCRequest{
+		Opcode:  gomemcached.SUBDOC_MULTI_MUTATION,
+		VBucket: vb,
+		Key:     []byte(key),
+		Body:    body,
+	})
+	if res == nil {
+		return nil, err
+	}
+	if err != nil && res.Status != gomemcached.SUBDOC_MULTI_PATH_FAILURE {
+		return nil, err
+	}
+
+	rv := &SubdocResult{
+		Cas:     res.Cas,
+		Results: make([]SubdocOpResult, len(specs)),
+	}
+	// index(1) status(2) [valuelen(4) value on success]
+	for pos = 0; pos < len(res.Body); {
+		if len(res.Body) < pos+3 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		i := int(res.Body[pos])
+		if i >= len(specs) {
+			return nil, fmt.Errorf("Invalid subdoc result index: %d", i)
+		}
+		rv.Results[i].Status = gomemcached.Status(
+			binary.BigEndian.Uint16(res.Body[pos+1:]))
+		pos += 3
+		if err != nil {
+			// Only the failing spec is reported.
+			break
+		}
+		if len(res.Body) < pos+4 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		vlen := int(binary.BigEndian.Uint32(res.Body[pos:]))
+		pos += 4
+		if len(res.Body) < pos+vlen {
+			return nil, io.ErrUnexpectedEOF
+		}
+		rv.Results[i].Value = res.Body[pos : pos+vlen]
+		pos += vlen
+	}
+	return rv, err
+}
@@ -0,0 +1,108 @@
+package memcached
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/dustin/gomemcached"
+)
+
+func TestLookupIn(t *testing.T) {
+	conn := newFakeConn(&gomemcached.MCResponse{
+		Opcode: gomemcached.SUBDOC_MULTI_LOOKUP,
+		Status: gomemcached.SUBDOC_MULTI_PATH_FAILURE,
+		Cas:    77,
+		Body: []byte{
+			0x00, 0x00, 0, 0, 0, 5, '"', 'b', 'o', 'b', '"',
+			0x00, 0xc0, 0, 0, 0, 0,
+		},
+	})
+	client, _ := Wrap(conn)
+
+	got, err := client.LookupIn(4, "doc", []SubdocSpec{
+		{Opcode: gomemcached.SUBDOC_GET, Path: "name"},
+		{Opcode: gomemcached.SUBDOC_EXISTS, Path: "age"},
+	})
+	if err != nil {
+		t.Fatalf("Error looking up: %v", err)
+	}
+
+	expected := &SubdocResult{
+		Cas: 77,
+		Results: []SubdocOpResult{
+			{gomemcached.SUCCESS, []byte(`"bob"`)},
+			{gomemcached.SUBDOC_PATH_ENOENT, []byte{}},
+		},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, got)
+	}
+
+	reqs := conn.requests(t)
+	expectedBody := []byte{
+		byte(gomemcached.SUBDOC_GET), 0, 0, 4, 'n', 'a', 'm', 'e',
+		byte(gomemcached.SUBDOC_EXISTS), 0, 0, 3, 'a', 'g', 'e',
+	}
+	if !bytes.Equal(reqs[0].Body, expectedBody) {
+		t.Errorf("Expected body %v, got %v", expectedBody, reqs[0].Body)
+	}
+}
+
+func TestMutateIn(t *testing.T) {
+	conn := newFakeConn(
+		&gomemcached.MCResponse{
+			Opcode: gomemcached.SUBDOC_MULTI_MUTATION,
+			Cas:    78,
+			Body:   []byte{0x01, 0x00, 0x00, 0, 0, 0, 1, '3'},
+		},
+		&gomemcached.MCResponse{
+			Opcode: gomemcached.SUBDOC_MULTI_MUTATION,
+			Status: gomemcached.SUBDOC_MULTI_PATH_FAILURE,
+			Body:   []byte{0x00, 0x00, 0xc9},
+		})
+	client, _ := Wrap(conn)
+
+	specs := []SubdocSpec{
+		{Opcode: gomemcached.SUBDOC_DICT_UPSERT, Path: "a",
+			Value: []byte("1")},
+		{Opcode: gomemcached.SUBDOC_COUNTER, Path: "n",
+			Value: []byte("2")},
+	}
+
+	got, err := client.MutateIn(4, "doc", specs)
+	if err != nil {
+		t.Fatalf("Error mutating: %v", err)
+	}
+	expected := &SubdocResult{
+		Cas: 78,
+		Results: []SubdocOpResult{
+			{gomemcached.SUCCESS, nil},
+			{gomemcached.SUCCESS, []byte("3")},
+		},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, got)
+	}
+
+	got, err = client.MutateIn(4, "doc", specs)
+	if res, ok := err.(*gomemcached.MCResponse); !ok ||
+		res.Status != gomemcached.SUBDOC_MULTI_PATH_FAILURE {
+		t.Fatalf("Expected multi path failure, got %v", err)
+	}
+	if got.Results[0].Status != gomemcached.SUBDOC_PATH_EEXISTS {
+		t.Errorf("Expected failing spec status, got %+v", got)
+	}
+	if !client.IsHealthy() {
+		t.Errorf("Expected path failure to be non-fatal")
+	}
+
+	reqs := conn.requests(t)
+	expectedBody := []byte{
+		byte(gomemcached.SUBDOC_DICT_UPSERT), 0, 0, 1, 0, 0, 0, 1, 'a', '1',
+		byte(gomemcached.SUBDOC_COUNTER), 0, 0, 1, 0, 0, 0, 1, 'n', '2',
+	}
+	if !bytes.Equal(reqs[0].Body, expectedBody) {
+		t.Errorf("Expected body %v, got %v", expectedBody, reqs[0].Body)
+	}
+}
@@ -99,7 +99,8 @@ func makeTapEvent(req gomemcached.MCRequest) *TapEvent {
 		return nil // unknown event
 	}
 
-	if event.Opcode == TapMutation || event.Opcode == TapDeletion {
+	if len(req.Extras) >= 8+4+4 &&
+		(event.Opcode == TapMutation || event.Opcode == TapDeletion) {
 		event.Flags = binary.BigEndian.Uint32(req.Extras[8:])
 		event.Expiry = binary.BigEndian.Uint32(req.Extras[12:])
 	}
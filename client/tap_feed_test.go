@@ -0,0 +1,50 @@
+package memcached
+
+import (
+	"testing"
+
+	"github.com/dustin/gomemcached"
+)
+
+func TestMakeTapEventFlags(t *testing.T) {
+	req := gomemcached.MCRequest{
+		Opcode:  gomemcached.TAP_MUTATION,
+		VBucket: 3,
+		Extras: []byte{
+			0, 0, 0, 0, 0, 0, 0, 0,
+			0xde, 0xad, 0xbe, 0xef, // flags
+			0, 0, 0x0e, 0x10, // expiry
+		},
+		Key:  []byte("k"),
+		Body: []byte("v"),
+	}
+
+	event := makeTapEvent(req)
+	if event == nil {
+		t.Fatalf("Expected an event for %v", req)
+	}
+	if event.Flags != 0xdeadbeef || event.Expiry != 3600 {
+		t.Errorf("Expected flags=deadbeef, exp=3600, got %v", event)
+	}
+}
+
+func TestMakeTapEventShortExtras(t *testing.T) {
+	for _, op := range []gomemcached.CommandCode{
+		gomemcached.TAP_MUTATION, gomemcached.TAP_DELETE} {
+
+		req := gomemcached.MCRequest{
+			Opcode: op,
+			Extras: []byte{0, 0, 0, 0, 0, 0, 0, 0},
+			Key:    []byte("k"),
+		}
+
+		event := makeTapEvent(req)
+		if event == nil {
+			t.Fatalf("Expected an event for %v", req)
+		}
+		if event.Flags != 0 || event.Expiry != 0 {
+			t.Errorf("Expected no flags or expiry for %v, got %v",
+				op, event)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package gomemcached
+
+import (
+	"fmt"
+)
+
+// Identifier of a flexible framing extras object.
+//
+// Request and response frame infos use separate ID spaces.
+type FrameInfoID uint16
+
+// Request frame info IDs
+const (
+	FRAME_BARRIER      = FrameInfoID(0x00)
+	FRAME_DURABILITY   = FrameInfoID(0x01)
+	FRAME_STREAM_ID    = FrameInfoID(0x02)
+	FRAME_OPEN_TRACING = FrameInfoID(0x03)
+)
+
+// Response frame info IDs
+const (
+	FRAME_SERVER_DURATION = FrameInfoID(0x00)
+)
+
+// A single flexible framing extras object.
+//
+// Both the ID and the length of Data must be less than 271 to be
+// encodable; Transmit rejects frame infos that aren't.
+type FrameInfo struct {
+	ID   FrameInfoID
+	Data []byte
+}
+
+// IDs and lengths below this are stored in the object's first byte;
+// larger values escape to an additional byte.
+const frameInfoEscape = 0x0f
+
+// Number of bytes this frame info consumes on the wire.
+func (f FrameInfo) Size() int {
+	rv := 1 + len(f.Data)
+	if f.ID >= frameInfoEscape {
+		rv++
+	}
+	if len(f.Data) >= frameInfoEscape {
+		rv++
+	}
+	return rv
+}
+
+func (f FrameInfo) fill(data []byte) int {
+	id, length := int(f.ID), len(f.Data)
+	pos := 1
+	if id >= frameInfoEscape {
+		data[pos] = byte(id - frameInfoEscape)
+		pos++
+		id = frameInfoEscape
+	}
+	if length >= frameInfoEscape {
+		data[pos] = byte(length - frameInfoEscape)
+		pos++
+		length = frameInfoEscape
+	}
+	data[0] = byte(id<<4 | length)
+	pos += copy(data[pos:], f.Data)
+	return pos
+}
+
+func framingExtrasLen(infos []FrameInfo) int {
+	rv := 0
+	for _, f := range infos {
+		rv += f.Size()
+	}
+	return rv
+}
+
+func fillFramingExtras(data []byte, infos []FrameInfo) int {
+	pos := 0
+	for _, f := range infos {
+		pos += f.fill(data[pos:])
+	}
+	return pos
+}
+
+// The largest frame info ID or data length the escape byte can encode.
+const maxFrameInfoValue = frameInfoEscape + 0xff
+
+// With flexible framing, the framing extras and key lengths are each
+// sent in a single header byte, and every frame info must be
+// encodable; Transmit rejects frame infos that aren't.
+func checkFlexFraming(infos []FrameInfo, klen int) error {
+	for _, f := range infos {
+		if f.ID > maxFrameInfoValue {
+			return fmt.Errorf("frame info id too large: %d > %d",
+				f.ID, maxFrameInfoValue)
+		}
+		if len(f.Data) > maxFrameInfoValue {
+			return fmt.Errorf("frame info %d too long: %d > %d",
+				f.ID, len(f.Data), maxFrameInfoValue)
+		}
+	}
+	flen := framingExtrasLen(infos)
+	if flen > 255 {
+		return fmt.Errorf("framing extras too long: %d > 255", flen)
+	}
+	if flen > 0 && klen > 255 {
+		return fmt.Errorf("key too long for flexible framing: %d > 255",
+			klen)
+	}
+	return nil
+}
+
+// Parse a block of framing extras into its individual objects.
+func parseFramingExtras(data []byte) ([]FrameInfo, error) {
+	rv := []FrameInfo{}
+	for pos := 0; pos < len(data); {
+		id := int(data[pos] >> 4)
+		length := int(data[pos] & 0x0f)
+		pos++
+		if id == frameInfoEscape {
+			if pos >= len(data) {
+				return rv, fmt.Errorf("truncated frame info id")
+			}
+			id += int(data[pos])
+			pos++
+		}
+		if length == frameInfoEscape {
+			if pos >= len(data) {
+				return rv, fmt.Errorf("truncated frame info length")
+			}
+			length += int(data[pos])
+			pos++
+		}
+		if pos+length > len(data) {
+			return rv, fmt.Errorf("frame info %d truncated: %d > %d",
+				id, length, len(data)-pos)
+		}
+		rv = append(rv, FrameInfo{
+			ID:   FrameInfoID(id),
+			Data: data[pos : pos+length],
+		})
+		pos += length
+	}
+	return rv, nil
+}
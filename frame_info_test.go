@@ -0,0 +1,194 @@
+package gomemcached
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestFrameInfoEncoding(t *testing.T) {
+	tests := []struct {
+		f        FrameInfo
+		expected []byte
+	}{
+		{FrameInfo{FRAME_BARRIER, []byte{}}, []byte{0x00}},
+		{FrameInfo{FRAME_STREAM_ID, []byte{0, 7}}, []byte{0x22, 0, 7}},
+		{FrameInfo{FrameInfoID(17), []byte{9}}, []byte{0xf1, 0x02, 9}},
+		{FrameInfo{FRAME_DURABILITY, make([]byte, 20)},
+			append([]byte{0x1f, 0x05}, make([]byte, 20)...)},
+	}
+
+	for _, test := range tests {
+		got := make([]byte, test.f.Size())
+		n := test.f.fill(got)
+		if n != len(got) || !bytes.Equal(got, test.expected) {
+			t.Errorf("Expected %v for %v, got %v (%d)",
+				test.expected, test.f, got, n)
+		}
+
+		parsed, err := parseFramingExtras(got)
+		if err != nil {
+			t.Errorf("Error parsing %v: %v", got, err)
+		}
+		if !reflect.DeepEqual(parsed, []FrameInfo{test.f}) {
+			t.Errorf("Expected %v, got %v", test.f, parsed)
+		}
+	}
+}
+
+func TestParseFramingExtrasTruncated(t *testing.T) {
+	for _, data := range [][]byte{
+		{0x22, 0},
+		{0xf0},
+		{0x0f},
+		{0x1f, 0x01, 0, 0},
+	} {
+		if _, err := parseFramingExtras(data); err == nil {
+			t.Errorf("Expected error parsing %v", data)
+		}
+	}
+}
+
+func TestReceiveTruncatedFlexHeader(t *testing.T) {
+	// Framing extras length 3, but a total length of 0
+	hdr := make([]byte, HDR_LEN)
+	hdr[2] = 3
+
+	hdr[0] = FLEX_REQ_MAGIC
+	req := MCRequest{}
+	if err := req.Receive(bytes.NewReader(hdr), nil); err == nil {
+		t.Errorf("Expected error receiving request %v", hdr)
+	}
+
+	hdr[0] = FLEX_RES_MAGIC
+	res := MCResponse{}
+	if err := res.Receive(bytes.NewReader(hdr), nil); err == nil {
+		t.Errorf("Expected error receiving response %v", hdr)
+	}
+}
+
+func TestTransmitFlexLengthLimits(t *testing.T) {
+	big := make([]byte, 256)
+	tests := []struct {
+		key   []byte
+		infos []FrameInfo
+	}{
+		{big, []FrameInfo{{FRAME_BARRIER, nil}}},
+		{nil, []FrameInfo{{FRAME_DURABILITY, big[:200]},
+			{FRAME_DURABILITY, big[:200]}}},
+		{nil, []FrameInfo{{FrameInfoID(271), []byte{1}}}},
+		{nil, []FrameInfo{{FrameInfoID(300), []byte{1}}}},
+	}
+
+	for _, test := range tests {
+		w := &bytes.Buffer{}
+		req := MCRequest{Opcode: GET, Key: test.key, FrameInfos: test.infos}
+		if err := req.Transmit(w); err == nil || w.Len() != 0 {
+			t.Errorf("Expected request error, got %v (%d bytes)",
+				err, w.Len())
+		}
+		res := MCResponse{Opcode: GET, Key: test.key, FrameInfos: test.infos}
+		if err := res.Transmit(w); err == nil || w.Len() != 0 {
+			t.Errorf("Expected response error, got %v (%d bytes)",
+				err, w.Len())
+		}
+	}
+
+	// The largest escaped ID still round trips.
+	req := MCRequest{Opcode: GET,
+		FrameInfos: []FrameInfo{{FrameInfoID(270), []byte{1}}}}
+	w := &bytes.Buffer{}
+	if err := req.Transmit(w); err != nil {
+		t.Fatalf("Unexpected error sending frame info 270: %v", err)
+	}
+	req2 := MCRequest{}
+	if err := req2.Receive(w, nil); err != nil ||
+		!reflect.DeepEqual(req2.FrameInfos, req.FrameInfos) {
+		t.Errorf("Expected %v, got %v (%v)",
+			req.FrameInfos, req2.FrameInfos, err)
+	}
+
+	// Without frame infos, long keys use the two byte length.
+	req = MCRequest{Opcode: GET, Key: big}
+	if err := req.Transmit(&bytes.Buffer{}); err != nil {
+		t.Errorf("Unexpected error sending long key: %v", err)
+	}
+}
+
+func TestEncodingRequestWithFrameInfos(t *testing.T) {
+	req := MCRequest{
+		Opcode:     GET,
+		Opaque:     7242,
+		VBucket:    824,
+		Key:        []byte("somekey"),
+		FrameInfos: []FrameInfo{{FRAME_STREAM_ID, []byte{0, 2}}},
+	}
+
+	got := req.Bytes()
+
+	expected := []byte{
+		FLEX_REQ_MAGIC, byte(GET),
+		0x3,       // length of framing extras
+		0x7,       // length of key
+		0x0,       // extra length
+		0x0,       // reserved
+		0x3, 0x38, // vbucket
+		0x0, 0x0, 0x0, 0xa, // Length of remainder
+		0x0, 0x0, 0x1c, 0x4a, // opaque
+		0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, // CAS
+		0x22, 0x0, 0x2, // stream id frame info
+		's', 'o', 'm', 'e', 'k', 'e', 'y'}
+
+	if len(got) != req.Size() {
+		t.Fatalf("Expected %v bytes, got %v", req.Size(), len(got))
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected:\n%#v\n  -- got -- \n%#v",
+			expected, got)
+	}
+
+	if !bytes.Equal(req.HeaderBytes(), got) {
+		t.Errorf("Expected header bytes to match for empty body")
+	}
+
+	req2 := MCRequest{}
+	err := req2.Receive(bytes.NewReader(got), nil)
+	if err != nil {
+		t.Fatalf("Error receiving: %v", err)
+	}
+	if !reflect.DeepEqual(req2.FrameInfos, req.FrameInfos) ||
+		string(req2.Key) != "somekey" || len(req2.Body) != 0 {
+		t.Errorf("Expected %v, got %v", req, req2)
+	}
+}
+
+func TestResponseFrameInfosRoundTrip(t *testing.T) {
+	res := MCResponse{
+		Opcode: SET,
+		Status: SUCCESS,
+		Opaque: 7242,
+		Cas:    938424885,
+		Extras: []byte{1, 2, 3, 4},
+		Key:    []byte("somekey"),
+		Body:   []byte("somevalue"),
+		FrameInfos: []FrameInfo{
+			{FRAME_SERVER_DURATION, []byte{0x12, 0x34}},
+		},
+	}
+
+	data := res.Bytes()
+	if data[0] != FLEX_RES_MAGIC || data[2] != 3 || data[3] != 7 {
+		t.Fatalf("Unexpected flex header: %v", data[:HDR_LEN])
+	}
+
+	res2 := MCResponse{}
+	err := res2.Receive(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("Error receiving: %v", err)
+	}
+
+	if !reflect.DeepEqual(res, res2) {
+		t.Fatalf("Expected %#v == %#v", res, res2)
+	}
+}
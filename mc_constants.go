@@ -3,11 +3,16 @@ package gomemcached
 
 import (
 	"fmt"
+	"strings"
 )
 
 const (
 	REQ_MAGIC = 0x80
 	RES_MAGIC = 0x81
+
+	// Magic for packets carrying flexible framing extras
+	FLEX_REQ_MAGIC = 0x08
+	FLEX_RES_MAGIC = 0x18
 )
 
 type CommandCode uint8
@@ -54,6 +59,10 @@ const (
 	RDECR      = CommandCode(0x3b)
 	RDECRQ     = CommandCode(0x3c)
 
+	TOUCH = CommandCode(0x1c)
+	GAT   = CommandCode(0x1d) // Get and touch
+	HELLO = CommandCode(0x1f)
+
 	SASL_LIST_MECHS = CommandCode(0x20)
 	SASL_AUTH       = CommandCode(0x21)
 	SASL_STEP       = CommandCode(0x22)
@@ -67,7 +76,35 @@ const (
 	TAP_CHECKPOINT_START = CommandCode(0x46) // Notifies start of new checkpoint
 	TAP_CHECKPOINT_END   = CommandCode(0x47) // Notifies end of checkpoint
 
-	OBSERVE = CommandCode(0x92)
+	GET_ALL_VB_SEQNOS = CommandCode(0x48) // Get current high seqno of every vbucket
+
+	SELECT_BUCKET = CommandCode(0x89)
+
+	OBSERVE_SEQNO = CommandCode(0x91)
+	OBSERVE       = CommandCode(0x92)
+	GETL          = CommandCode(0x94) // Get and lock
+	UNLOCK_KEY    = CommandCode(0x95)
+
+	GET_META      = CommandCode(0xa0)
+	SET_WITH_META = CommandCode(0xa2)
+	DEL_WITH_META = CommandCode(0xa8)
+
+	SUBDOC_GET              = CommandCode(0xc5)
+	SUBDOC_EXISTS           = CommandCode(0xc6)
+	SUBDOC_DICT_ADD         = CommandCode(0xc7)
+	SUBDOC_DICT_UPSERT      = CommandCode(0xc8)
+	SUBDOC_DELETE           = CommandCode(0xc9)
+	SUBDOC_REPLACE          = CommandCode(0xca)
+	SUBDOC_ARRAY_PUSH_LAST  = CommandCode(0xcb)
+	SUBDOC_ARRAY_PUSH_FIRST = CommandCode(0xcc)
+	SUBDOC_ARRAY_INSERT     = CommandCode(0xcd)
+	SUBDOC_ARRAY_ADD_UNIQUE = CommandCode(0xce)
+	SUBDOC_COUNTER          = CommandCode(0xcf)
+	SUBDOC_MULTI_LOOKUP     = CommandCode(0xd0)
+	SUBDOC_MULTI_MUTATION   = CommandCode(0xd1)
+	SUBDOC_GET_COUNT        = CommandCode(0xd2)
+
+	GET_ERROR_MAP = CommandCode(0xfe)
 )
 
 type Status uint16
@@ -81,11 +118,61 @@ const (
 	NOT_STORED      = Status(0x05)
 	DELTA_BADVAL    = Status(0x06)
 	NOT_MY_VBUCKET  = Status(0x07)
+	LOCKED          = Status(0x09)
+	AUTH_ERROR      = Status(0x20)
+	AUTH_CONTINUE   = Status(0x21)
+	EACCESS         = Status(0x24)
 	UNKNOWN_COMMAND = Status(0x81)
 	ENOMEM          = Status(0x82)
+	NOT_SUPPORTED   = Status(0x83)
 	TMPFAIL         = Status(0x86)
+
+	SUBDOC_PATH_ENOENT        = Status(0xc0)
+	SUBDOC_PATH_MISMATCH      = Status(0xc1)
+	SUBDOC_PATH_EINVAL        = Status(0xc2)
+	SUBDOC_DOC_NOTJSON        = Status(0xc6)
+	SUBDOC_PATH_EEXISTS       = Status(0xc9)
+	SUBDOC_MULTI_PATH_FAILURE = Status(0xcc)
+)
+
+// Datatype bits describing an item's value
+const (
+	DATATYPE_RAW    = uint8(0x00)
+	DATATYPE_JSON   = uint8(0x01)
+	DATATYPE_SNAPPY = uint8(0x02)
+	DATATYPE_XATTR  = uint8(0x04)
 )
 
+// True if the datatype says the value is JSON.
+func IsJSONDatatype(dt uint8) bool {
+	return dt&DATATYPE_JSON != 0
+}
+
+// Render datatype bits for logging, e.g. "json+snappy".
+func DatatypeString(dt uint8) string {
+	if dt == DATATYPE_RAW {
+		return "raw"
+	}
+	parts := []string{}
+	for _, x := range []struct {
+		bit  uint8
+		name string
+	}{
+		{DATATYPE_JSON, "json"},
+		{DATATYPE_SNAPPY, "snappy"},
+		{DATATYPE_XATTR, "xattr"},
+	} {
+		if dt&x.bit != 0 {
+			parts = append(parts, x.name)
+			dt &^= x.bit
+		}
+	}
+	if dt != 0 {
+		parts = append(parts, fmt.Sprintf("0x%02x", dt))
+	}
+	return strings.Join(parts, "+")
+}
+
 // An internal representation of an item.
 type MCItem struct {
 	Cas               uint64
@@ -144,6 +231,10 @@ func init() {
 	CommandNames[RDECR] = "RDECR"
 	CommandNames[RDECRQ] = "RDECRQ"
 
+	CommandNames[TOUCH] = "TOUCH"
+	CommandNames[GAT] = "GAT"
+	CommandNames[HELLO] = "HELLO"
+
 	CommandNames[SASL_LIST_MECHS] = "SASL_LIST_MECHS"
 	CommandNames[SASL_AUTH] = "SASL_AUTH"
 	CommandNames[SASL_STEP] = "SASL_STEP"
@@ -157,6 +248,33 @@ func init() {
 	CommandNames[TAP_CHECKPOINT_START] = "TAP_CHECKPOINT_START"
 	CommandNames[TAP_CHECKPOINT_END] = "TAP_CHECKPOINT_END"
 
+	CommandNames[SELECT_BUCKET] = "SELECT_BUCKET"
+	CommandNames[OBSERVE_SEQNO] = "OBSERVE_SEQNO"
+	CommandNames[GETL] = "GETL"
+	CommandNames[UNLOCK_KEY] = "UNLOCK_KEY"
+	CommandNames[GET_META] = "GET_META"
+	CommandNames[SET_WITH_META] = "SET_WITH_META"
+	CommandNames[DEL_WITH_META] = "DEL_WITH_META"
+
+	CommandNames[SUBDOC_GET] = "SUBDOC_GET"
+	CommandNames[SUBDOC_EXISTS] = "SUBDOC_EXISTS"
+	CommandNames[SUBDOC_DICT_ADD] = "SUBDOC_DICT_ADD"
+	CommandNames[SUBDOC_DICT_UPSERT] = "SUBDOC_DICT_UPSERT"
+	CommandNames[SUBDOC_DELETE] = "SUBDOC_DELETE"
+	CommandNames[SUBDOC_REPLACE] = "SUBDOC_REPLACE"
+	CommandNames[SUBDOC_ARRAY_PUSH_LAST] = "SUBDOC_ARRAY_PUSH_LAST"
+	CommandNames[SUBDOC_ARRAY_PUSH_FIRST] = "SUBDOC_ARRAY_PUSH_FIRST"
+	CommandNames[SUBDOC_ARRAY_INSERT] = "SUBDOC_ARRAY_INSERT"
+	CommandNames[SUBDOC_ARRAY_ADD_UNIQUE] = "SUBDOC_ARRAY_ADD_UNIQUE"
+	CommandNames[SUBDOC_COUNTER] = "SUBDOC_COUNTER"
+	CommandNames[SUBDOC_MULTI_LOOKUP] = "SUBDOC_MULTI_LOOKUP"
+	CommandNames[SUBDOC_MULTI_MUTATION] = "SUBDOC_MULTI_MUTATION"
+	CommandNames[SUBDOC_GET_COUNT] = "SUBDOC_GET_COUNT"
+
+	CommandNames[GET_ERROR_MAP] = "GET_ERROR_MAP"
+
+	CommandNames[GET_ALL_VB_SEQNOS] = "GET_ALL_VB_SEQNOS"
+
 	StatusNames = make(map[Status]string)
 	StatusNames[SUCCESS] = "SUCCESS"
 	StatusNames[KEY_ENOENT] = "KEY_ENOENT"
@@ -166,10 +284,22 @@ func init() {
 	StatusNames[NOT_STORED] = "NOT_STORED"
 	StatusNames[DELTA_BADVAL] = "DELTA_BADVAL"
 	StatusNames[NOT_MY_VBUCKET] = "NOT_MY_VBUCKET"
+	StatusNames[LOCKED] = "LOCKED"
+	StatusNames[AUTH_ERROR] = "AUTH_ERROR"
+	StatusNames[AUTH_CONTINUE] = "AUTH_CONTINUE"
+	StatusNames[EACCESS] = "EACCESS"
 	StatusNames[UNKNOWN_COMMAND] = "UNKNOWN_COMMAND"
 	StatusNames[ENOMEM] = "ENOMEM"
+	StatusNames[NOT_SUPPORTED] = "NOT_SUPPORTED"
 	StatusNames[TMPFAIL] = "TMPFAIL"
 
+	StatusNames[SUBDOC_PATH_ENOENT] = "SUBDOC_PATH_ENOENT"
+	StatusNames[SUBDOC_PATH_MISMATCH] = "SUBDOC_PATH_MISMATCH"
+	StatusNames[SUBDOC_PATH_EINVAL] = "SUBDOC_PATH_EINVAL"
+	StatusNames[SUBDOC_DOC_NOTJSON] = "SUBDOC_DOC_NOTJSON"
+	StatusNames[SUBDOC_PATH_EEXISTS] = "SUBDOC_PATH_EEXISTS"
+	StatusNames[SUBDOC_MULTI_PATH_FAILURE] = "SUBDOC_MULTI_PATH_FAILURE"
+
 }
 
 // String an op code.
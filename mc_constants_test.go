@@ -37,3 +37,29 @@ func TestIsQuiet(t *testing.T) {
 		}
 	}
 }
+
+func TestDatatype(t *testing.T) {
+	tests := []struct {
+		dt   uint8
+		json bool
+		s    string
+	}{
+		{DATATYPE_RAW, false, "raw"},
+		{DATATYPE_JSON, true, "json"},
+		{DATATYPE_SNAPPY, false, "snappy"},
+		{DATATYPE_JSON | DATATYPE_SNAPPY, true, "json+snappy"},
+		{DATATYPE_JSON | DATATYPE_SNAPPY | DATATYPE_XATTR, true,
+			"json+snappy+xattr"},
+		{DATATYPE_XATTR | 0x80, false, "xattr+0x80"},
+	}
+
+	for _, x := range tests {
+		if IsJSONDatatype(x.dt) != x.json {
+			t.Errorf("Expected json=%v for 0x%02x", x.json, x.dt)
+		}
+		if DatatypeString(x.dt) != x.s {
+			t.Errorf("Expected %q for 0x%02x, got %q",
+				x.s, x.dt, DatatypeString(x.dt))
+		}
+	}
+}
@@ -22,11 +22,16 @@ type MCRequest struct {
 	VBucket uint16
 	// Command extras, key, and body
 	Extras, Key, Body []byte
+	// Flexible framing extras.  When present, the request is sent
+	// with FLEX_REQ_MAGIC, and both the encoded frame infos and the
+	// key may be at most 255 bytes; Transmit rejects anything larger.
+	FrameInfos []FrameInfo
 }
 
 // The number of bytes this request requires.
 func (req *MCRequest) Size() int {
-	return HDR_LEN + len(req.Extras) + len(req.Key) + len(req.Body)
+	return HDR_LEN + framingExtrasLen(req.FrameInfos) +
+		len(req.Extras) + len(req.Key) + len(req.Body)
 }
 
 // A debugging string representation of this request
@@ -36,14 +41,24 @@ func (req MCRequest) String() string {
 }
 
 func (req *MCRequest) fillHeaderBytes(data []byte) int {
+	flen := framingExtrasLen(req.FrameInfos)
 
 	pos := 0
-	data[pos] = REQ_MAGIC
+	if flen > 0 {
+		data[pos] = FLEX_REQ_MAGIC
+	} else {
+		data[pos] = REQ_MAGIC
+	}
 	pos++
 	data[pos] = byte(req.Opcode)
 	pos++
-	binary.BigEndian.PutUint16(data[pos:pos+2],
-		uint16(len(req.Key)))
+	if flen > 0 {
+		data[pos] = byte(flen)
+		data[pos+1] = byte(len(req.Key))
+	} else {
+		binary.BigEndian.PutUint16(data[pos:pos+2],
+			uint16(len(req.Key)))
+	}
 	pos += 2
 
 	// 4
@@ -56,7 +71,7 @@ func (req *MCRequest) fillHeaderBytes(data []byte) int {
 
 	// 8
 	binary.BigEndian.PutUint32(data[pos:pos+4],
-		uint32(len(req.Body)+len(req.Key)+len(req.Extras)))
+		uint32(flen+len(req.Body)+len(req.Key)+len(req.Extras)))
 	pos += 4
 
 	// 12
@@ -69,6 +84,8 @@ func (req *MCRequest) fillHeaderBytes(data []byte) int {
 	}
 	pos += 8
 
+	pos += fillFramingExtras(data[pos:], req.FrameInfos)
+
 	if len(req.Extras) > 0 {
 		copy(data[pos:pos+len(req.Extras)], req.Extras)
 		pos += len(req.Extras)
@@ -83,7 +100,7 @@ func (req *MCRequest) fillHeaderBytes(data []byte) int {
 
 // The wire representation of the header (with the extras and key)
 func (req *MCRequest) HeaderBytes() []byte {
-	data := make([]byte, HDR_LEN+len(req.Extras)+len(req.Key))
+	data := make([]byte, req.Size()-len(req.Body))
 
 	req.fillHeaderBytes(data)
 
@@ -105,6 +122,10 @@ func (req *MCRequest) Bytes() []byte {
 
 // Send this request message across a writer.
 func (req *MCRequest) Transmit(w io.Writer) (err error) {
+	err = checkFlexFraming(req.FrameInfos, len(req.Key))
+	if err != nil {
+		return err
+	}
 	if len(req.Body) < 128 {
 		_, err = w.Write(req.Bytes())
 	} else {
@@ -129,27 +150,42 @@ func (req *MCRequest) Receive(r io.Reader, hdrBytes []byte) error {
 		return err
 	}
 
-	if hdrBytes[0] != RES_MAGIC && hdrBytes[0] != REQ_MAGIC {
+	flen := 0
+	klen := 0
+	switch hdrBytes[0] {
+	case RES_MAGIC, REQ_MAGIC:
+		klen = int(binary.BigEndian.Uint16(hdrBytes[2:]))
+	case FLEX_RES_MAGIC, FLEX_REQ_MAGIC:
+		flen = int(hdrBytes[2])
+		klen = int(hdrBytes[3])
+	default:
 		return fmt.Errorf("Bad magic: 0x%02x", hdrBytes[0])
 	}
-
-	klen := int(binary.BigEndian.Uint16(hdrBytes[2:]))
 	elen := int(hdrBytes[4])
+	totalLen := int(binary.BigEndian.Uint32(hdrBytes[8:]))
+	if flen+klen+elen > totalLen {
+		return fmt.Errorf("Header lengths %d+%d+%d exceed total length %d",
+			flen, klen, elen, totalLen)
+	}
 
 	req.Opcode = CommandCode(hdrBytes[1])
 	// Vbucket at 6:7
 	req.VBucket = binary.BigEndian.Uint16(hdrBytes[6:])
-	bodyLen := int(binary.BigEndian.Uint32(hdrBytes[8:]) -
-		uint32(klen) - uint32(elen))
+	bodyLen := totalLen - (flen + klen + elen)
 	if bodyLen > MaxBodyLen {
 		return fmt.Errorf("%d is too big (max %s)",
 			bodyLen, MaxBodyLen)
 	}
 	req.Opaque = binary.BigEndian.Uint32(hdrBytes[12:])
 	req.Cas = binary.BigEndian.Uint64(hdrBytes[16:])
+	req.FrameInfos = nil
 
-	buf := make([]byte, klen+elen+bodyLen)
+	buf := make([]byte, flen+klen+elen+bodyLen)
 	_, err = io.ReadFull(r, buf)
+	if err == nil && flen > 0 {
+		req.FrameInfos, err = parseFramingExtras(buf[:flen])
+		buf = buf[flen:]
+	}
 	if err == nil {
 		if req.Opcode >= TAP_MUTATION &&
 			req.Opcode <= TAP_CHECKPOINT_END &&
@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
+	"time"
 )
 
 // A memcached response
@@ -18,6 +20,10 @@ type MCResponse struct {
 	Cas uint64
 	// Extras, key, and body for this response
 	Extras, Key, Body []byte
+	// Flexible framing extras.  When present, the response is sent
+	// with FLEX_RES_MAGIC, and both the encoded frame infos and the
+	// key may be at most 255 bytes; Transmit rejects anything larger.
+	FrameInfos []FrameInfo
 	// If true, this represents a fatal condition and we should hang up
 	Fatal bool
 }
@@ -50,31 +56,66 @@ func IsNotFound(e error) bool {
 	return errStatus(e) == KEY_ENOENT
 }
 
+// True if this error represents a "locked" response, e.g. from
+// getting or modifying an item held by another client's lock.
+func IsLocked(e error) bool {
+	return errStatus(e) == LOCKED
+}
+
 // False if this error isn't believed to be fatal to a connection.
 func IsFatal(e error) bool {
 	if e == nil {
 		return false
 	}
 	switch errStatus(e) {
-	case KEY_ENOENT, KEY_EEXISTS, NOT_STORED, TMPFAIL:
+	case KEY_ENOENT, KEY_EEXISTS, NOT_STORED, LOCKED, TMPFAIL,
+		SUBDOC_MULTI_PATH_FAILURE, NOT_SUPPORTED, EACCESS:
 		return false
 	}
 	return true
 }
 
+// The time the server reports having spent processing the request.
+//
+// Only available when the server attached a server duration frame
+// info; ok is false otherwise.
+func (res *MCResponse) ServerDuration() (d time.Duration, ok bool) {
+	for _, f := range res.FrameInfos {
+		if f.ID == FRAME_SERVER_DURATION && len(f.Data) == 2 {
+			// The server encodes microseconds as (2 * us) ^ (1 / 1.74)
+			encoded := float64(binary.BigEndian.Uint16(f.Data))
+			us := math.Pow(encoded, 1.74) / 2
+			return time.Duration(us * float64(time.Microsecond)), true
+		}
+	}
+	return 0, false
+}
+
 // Number of bytes this response consumes on the wire.
 func (res *MCResponse) Size() int {
-	return HDR_LEN + len(res.Extras) + len(res.Key) + len(res.Body)
+	return HDR_LEN + framingExtrasLen(res.FrameInfos) +
+		len(res.Extras) + len(res.Key) + len(res.Body)
 }
 
 func (res *MCResponse) fillHeaderBytes(data []byte) int {
+	flen := framingExtrasLen(res.FrameInfos)
+
 	pos := 0
-	data[pos] = RES_MAGIC
+	if flen > 0 {
+		data[pos] = FLEX_RES_MAGIC
+	} else {
+		data[pos] = RES_MAGIC
+	}
 	pos++
 	data[pos] = byte(res.Opcode)
 	pos++
-	binary.BigEndian.PutUint16(data[pos:pos+2],
-		uint16(len(res.Key)))
+	if flen > 0 {
+		data[pos] = byte(flen)
+		data[pos+1] = byte(len(res.Key))
+	} else {
+		binary.BigEndian.PutUint16(data[pos:pos+2],
+			uint16(len(res.Key)))
+	}
 	pos += 2
 
 	// 4
@@ -87,7 +128,7 @@ func (res *MCResponse) fillHeaderBytes(data []byte) int {
 
 	// 8
 	binary.BigEndian.PutUint32(data[pos:pos+4],
-		uint32(len(res.Body)+len(res.Key)+len(res.Extras)))
+		uint32(flen+len(res.Body)+len(res.Key)+len(res.Extras)))
 	pos += 4
 
 	// 12
@@ -98,6 +139,8 @@ func (res *MCResponse) fillHeaderBytes(data []byte) int {
 	binary.BigEndian.PutUint64(data[pos:pos+8], res.Cas)
 	pos += 8
 
+	pos += fillFramingExtras(data[pos:], res.FrameInfos)
+
 	if len(res.Extras) > 0 {
 		copy(data[pos:pos+len(res.Extras)], res.Extras)
 		pos += len(res.Extras)
@@ -113,7 +156,7 @@ func (res *MCResponse) fillHeaderBytes(data []byte) int {
 
 // Get just the header bytes for this response.
 func (res *MCResponse) HeaderBytes() []byte {
-	data := make([]byte, HDR_LEN+len(res.Extras)+len(res.Key))
+	data := make([]byte, res.Size()-len(res.Body))
 
 	res.fillHeaderBytes(data)
 
@@ -133,6 +176,10 @@ func (res *MCResponse) Bytes() []byte {
 
 // Send this response message across a writer.
 func (res *MCResponse) Transmit(w io.Writer) (err error) {
+	err = checkFlexFraming(res.FrameInfos, len(res.Key))
+	if err != nil {
+		return err
+	}
 	if len(res.Body) < 128 {
 		_, err = w.Write(res.Bytes())
 	} else {
@@ -157,22 +204,38 @@ func (req *MCResponse) Receive(r io.Reader, hdrBytes []byte) error {
 		return err
 	}
 
-	if hdrBytes[0] != RES_MAGIC && hdrBytes[0] != REQ_MAGIC {
+	flen := 0
+	klen := 0
+	switch hdrBytes[0] {
+	case RES_MAGIC, REQ_MAGIC:
+		klen = int(binary.BigEndian.Uint16(hdrBytes[2:4]))
+	case FLEX_RES_MAGIC, FLEX_REQ_MAGIC:
+		flen = int(hdrBytes[2])
+		klen = int(hdrBytes[3])
+	default:
 		return fmt.Errorf("Bad magic: 0x%02x", hdrBytes[0])
 	}
-
-	klen := int(binary.BigEndian.Uint16(hdrBytes[2:4]))
 	elen := int(hdrBytes[4])
+	totalLen := int(binary.BigEndian.Uint32(hdrBytes[8:12]))
+	if flen+klen+elen > totalLen {
+		return fmt.Errorf("Header lengths %d+%d+%d exceed total length %d",
+			flen, klen, elen, totalLen)
+	}
 
 	req.Opcode = CommandCode(hdrBytes[1])
 	req.Status = Status(binary.BigEndian.Uint16(hdrBytes[6:8]))
 	req.Opaque = binary.BigEndian.Uint32(hdrBytes[12:16])
 	req.Cas = binary.BigEndian.Uint64(hdrBytes[16:24])
+	req.FrameInfos = nil
 
-	bodyLen := int(binary.BigEndian.Uint32(hdrBytes[8:12])) - (klen + elen)
+	bodyLen := totalLen - (flen + klen + elen)
 
-	buf := make([]byte, klen+elen+bodyLen)
+	buf := make([]byte, flen+klen+elen+bodyLen)
 	_, err = io.ReadFull(r, buf)
+	if err == nil && flen > 0 {
+		req.FrameInfos, err = parseFramingExtras(buf[:flen])
+		buf = buf[flen:]
+	}
 	if err == nil {
 		req.Extras = buf[0:elen]
 		req.Key = buf[elen : klen+elen]
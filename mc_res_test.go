@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestEncodingResponse(t *testing.T) {
@@ -188,6 +189,25 @@ func TestIsNotFound(t *testing.T) {
 	}
 }
 
+func TestIsLocked(t *testing.T) {
+	tests := []struct {
+		e  error
+		is bool
+	}{
+		{nil, false},
+		{errors.New("something"), false},
+		{&MCResponse{Status: TMPFAIL}, false},
+		{MCResponse{Status: LOCKED}, true},
+		{&MCResponse{Status: LOCKED}, true},
+	}
+
+	for i, x := range tests {
+		if IsLocked(x.e) != x.is {
+			t.Errorf("Expected %v for %#v (%v)", x.is, x.e, i)
+		}
+	}
+}
+
 func TestIsFatal(t *testing.T) {
 	tests := []struct {
 		e  error
@@ -199,6 +219,14 @@ func TestIsFatal(t *testing.T) {
 		{&MCResponse{}, true},
 		{MCResponse{Status: KEY_ENOENT}, false},
 		{&MCResponse{Status: KEY_ENOENT}, false},
+		{MCResponse{Status: LOCKED}, false},
+		{&MCResponse{Status: LOCKED}, false},
+		{MCResponse{Status: SUBDOC_MULTI_PATH_FAILURE}, false},
+		{&MCResponse{Status: SUBDOC_MULTI_PATH_FAILURE}, false},
+		{MCResponse{Status: NOT_SUPPORTED}, false},
+		{&MCResponse{Status: NOT_SUPPORTED}, false},
+		{MCResponse{Status: EACCESS}, false},
+		{&MCResponse{Status: EACCESS}, false},
 		{MCResponse{Status: EINVAL}, true},
 		{&MCResponse{Status: EINVAL}, true},
 		{MCResponse{Status: TMPFAIL}, false},
@@ -404,3 +432,38 @@ func BenchmarkReceiveResponseNoBuf(b *testing.B) {
 		res2.Receive(rdr, nil)
 	}
 }
+
+func TestServerDuration(t *testing.T) {
+	data := []byte{
+		FLEX_RES_MAGIC, byte(GET),
+		0x3,      // length of framing extras
+		0x0,      // length of key
+		0x0,      // extra length
+		0x0,      // data type
+		0x0, 0x0, // status
+		0x0, 0x0, 0x0, 0x3, // Length of remainder
+		0x0, 0x0, 0x0, 0x0, // opaque
+		0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, // CAS
+		0x02, 0x03, 0xe8, // server duration frame info
+	}
+
+	res := MCResponse{}
+	err := res.Receive(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("Error receiving: %v", err)
+	}
+
+	d, ok := res.ServerDuration()
+	if !ok {
+		t.Fatalf("Expected a server duration in %#v", res)
+	}
+	// 1000 ^ 1.74 / 2 microseconds
+	if d < 82979*time.Microsecond || d > 82980*time.Microsecond {
+		t.Errorf("Expected ~82.979ms, got %v", d)
+	}
+
+	res.FrameInfos = nil
+	if d, ok := res.ServerDuration(); ok {
+		t.Errorf("Expected no server duration, got %v", d)
+	}
+}